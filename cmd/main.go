@@ -9,6 +9,8 @@
 package main
 
 import (
+	"os"
+
 	"github.com/edgexfoundry/device-sdk-go/pkg/startup"
 	"github.com/intel/rsp-sw-toolkit-im-suite-mqtt-device-service/internal/driver"
 )
@@ -20,4 +22,5 @@ const (
 func main() {
 	mqttDriver := driver.NewProtocolDriver()
 	startup.Bootstrap(serviceName, Version, mqttDriver)
+	os.Exit(driver.ExitCode())
 }
@@ -1,8 +1,9 @@
 module github.com/intel/rsp-sw-toolkit-im-suite-mqtt-device-service
 
-go 1.12
+go 1.15
 
 require (
+	github.com/eclipse/paho.golang v0.10.0
 	github.com/eclipse/paho.mqtt.golang v1.2.0
 	github.com/edgexfoundry/device-sdk-go v1.0.0
 	github.com/edgexfoundry/go-mod-core-contracts v0.1.0
@@ -14,6 +15,7 @@ require (
 	github.com/intel/rsp-sw-toolkit-im-suite-tagcode v1.2.1
 	github.com/kr/pretty v0.1.0 // indirect
 	github.com/pkg/errors v0.8.1
-	golang.org/x/net v0.0.0-20190213061140-3a22650c66bd // indirect
+	golang.org/x/net v0.0.0-20190213061140-3a22650c66bd
 	gopkg.in/check.v1 v1.0.0-20180628173108-788fd7840127 // indirect
+	gopkg.in/yaml.v2 v2.2.2
 )
@@ -0,0 +1,132 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package driver
+
+import (
+	"github.com/pkg/errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// backoffMultiplier is the factor the wait grows by after every failed attempt
+const backoffMultiplier = 2
+
+// backoff computes the wait before each retry of an operation: it starts at initial
+// and doubles with every attempt up to max. The wait is shortened by a random part of
+// up to jitter percent of it, so that services which failed together don't retry in
+// lockstep. A backoff is used by a single retry loop at a time.
+type backoff struct {
+	initial time.Duration
+	max     time.Duration
+	jitter  int
+
+	attempts int
+	random   *rand.Rand
+}
+
+// newBackoff creates a backoff based on the retry settings of the driver config
+func (config *configuration) newBackoff() *backoff {
+	return &backoff{
+		initial: time.Duration(config.RetryInitialWaitMillis) * time.Millisecond,
+		max:     time.Duration(config.RetryMaxWaitSeconds) * time.Second,
+		jitter:  config.RetryJitterPercent,
+		random:  rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// validateRetry checks the retry settings of the config
+func (config *configuration) validateRetry() error {
+	if config.RetryInitialWaitMillis <= 0 {
+		return errors.Errorf("%s must be positive", RetryInitialWaitMillis)
+	}
+	if time.Duration(config.RetryMaxWaitSeconds)*time.Second < time.Duration(config.RetryInitialWaitMillis)*time.Millisecond {
+		return errors.Errorf("%s must not be shorter than %s", RetryMaxWaitSeconds, RetryInitialWaitMillis)
+	}
+	if config.RetryJitterPercent < 0 || config.RetryJitterPercent > 100 {
+		return errors.Errorf("%s must be between 0 and 100", RetryJitterPercent)
+	}
+	return nil
+}
+
+// next counts a failed attempt and returns how long to wait before the next one
+func (b *backoff) next() time.Duration {
+	wait := b.initial
+	for i := 0; i < b.attempts && wait < b.max; i++ {
+		wait *= backoffMultiplier
+	}
+	if wait > b.max {
+		wait = b.max
+	}
+	b.attempts++
+
+	if spread := int64(wait) * int64(b.jitter) / 100; spread > 0 {
+		wait -= time.Duration(b.random.Int63n(spread + 1))
+	}
+	return wait
+}
+
+// retryState describes the retries the driver is waiting on
+type retryState struct {
+	// ConnectRetries counts the rounds of connection attempts waited for since the
+	// last connection, and NextConnect is when the next round starts
+	ConnectRetries int       `json:"connectRetries"`
+	NextConnect    time.Time `json:"nextConnect"`
+	// Subscriptions are the failed attempts of each topic filter not yet subscribed to
+	Subscriptions map[string]int `json:"subscriptions"`
+}
+
+// retries tracks the retryState of a driver
+type retries struct {
+	mutex sync.Mutex
+	state retryState
+}
+
+// connectFailed records waiting for the next round of connection attempts
+func (r *retries) connectFailed(count int, wait time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.state.ConnectRetries = count
+	r.state.NextConnect = time.Now().Add(wait)
+}
+
+// connected records a successful connection
+func (r *retries) connected() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.state.ConnectRetries = 0
+	r.state.NextConnect = time.Time{}
+}
+
+// subscribeFailed records a failed attempt to subscribe to the filter
+func (r *retries) subscribeFailed(filter string, attempts int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.state.Subscriptions == nil {
+		r.state.Subscriptions = map[string]int{}
+	}
+	r.state.Subscriptions[filter] = attempts
+}
+
+// subscribed records that the filter is subscribed to, or that it's no longer retried
+func (r *retries) subscribed(filter string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.state.Subscriptions, filter)
+}
+
+// snapshot returns a copy of the current retryState
+func (r *retries) snapshot() retryState {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	state := r.state
+	state.Subscriptions = make(map[string]int, len(r.state.Subscriptions))
+	for filter, attempts := range r.state.Subscriptions {
+		state.Subscriptions[filter] = attempts
+	}
+	return state
+}
@@ -0,0 +1,65 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package driver
+
+import (
+	expect "github.com/intel/rsp-sw-toolkit-im-suite-expect"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	w := expect.WrapT(t)
+
+	b := (&configuration{RetryInitialWaitMillis: 1000, RetryMaxWaitSeconds: 5}).newBackoff()
+	for _, expected := range []time.Duration{1, 2, 4, 5, 5} {
+		w.ShouldBeEqual(b.next(), expected*time.Second)
+	}
+	w.ShouldBeEqual(b.attempts, 5)
+
+	b = (&configuration{RetryInitialWaitMillis: 1000, RetryMaxWaitSeconds: 60, RetryJitterPercent: 50}).newBackoff()
+	for i := 0; i < 100; i++ {
+		wait := b.next()
+		expected := time.Second << uint(i)
+		if expected > time.Minute || expected <= 0 {
+			expected = time.Minute
+		}
+		w.As(b.attempts).ShouldBeTrue(wait >= expected/2 && wait <= expected)
+	}
+}
+
+func TestValidateRetry(t *testing.T) {
+	w := expect.WrapT(t)
+	w.ShouldSucceed((&configuration{RetryInitialWaitMillis: 1000, RetryMaxWaitSeconds: 1, RetryJitterPercent: 100}).validateRetry())
+	w.ShouldFail((&configuration{RetryInitialWaitMillis: 0, RetryMaxWaitSeconds: 1}).validateRetry())
+	w.ShouldFail((&configuration{RetryInitialWaitMillis: 2000, RetryMaxWaitSeconds: 1}).validateRetry())
+	w.ShouldFail((&configuration{RetryInitialWaitMillis: 1000, RetryMaxWaitSeconds: 1, RetryJitterPercent: 101}).validateRetry())
+}
+
+func TestRetries(t *testing.T) {
+	w := expect.WrapT(t)
+	r := &retries{}
+
+	r.connectFailed(2, time.Minute)
+	r.subscribeFailed("rfid/rsp/data/+", 1)
+	r.subscribeFailed("rfid/rsp/data/+", 2)
+	state := r.snapshot()
+	w.ShouldBeEqual(state.ConnectRetries, 2)
+	w.ShouldBeTrue(state.NextConnect.After(time.Now()))
+	w.ShouldBeEqual(state.Subscriptions, map[string]int{"rfid/rsp/data/+": 2})
+
+	// the snapshot is a copy
+	state.Subscriptions["rfid/controller/response"] = 1
+	w.ShouldBeEqual(len(r.snapshot().Subscriptions), 1)
+
+	r.connected()
+	r.subscribed("rfid/rsp/data/+")
+	state = r.snapshot()
+	w.ShouldBeEqual(state.ConnectRetries, 0)
+	w.ShouldBeTrue(state.NextConnect.IsZero())
+	w.ShouldBeEqual(len(state.Subscriptions), 0)
+}
@@ -0,0 +1,318 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package driver
+
+import (
+	"fmt"
+	"github.com/eclipse/paho.mqtt.golang"
+	"github.com/pkg/errors"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// failbackProbeTimeout is how long to wait for the first broker to accept a TCP
+// connection when checking whether to fail back to it
+const failbackProbeTimeout = 5 * time.Second
+
+// brokerSchemes are the URI schemes supported by the mqtt client
+var brokerSchemes = map[string]bool{
+	"tcp":  true,
+	"ssl":  true,
+	"tls":  true,
+	"tcps": true,
+	"ws":   true,
+	"wss":  true,
+}
+
+// mqttBrokers returns the URIs of the MqttBrokers in order of preference.
+// If none are configured, the single broker of the MqttScheme, MqttHost and
+// MqttPort is used.
+func (config *configuration) mqttBrokers() ([]*url.URL, error) {
+	if len(config.MqttBrokers) == 0 {
+		uri := &url.URL{
+			Scheme: strings.ToLower(config.MqttScheme),
+			Host:   fmt.Sprintf("%s:%s", config.MqttHost, config.MqttPort),
+		}
+		if !brokerSchemes[uri.Scheme] {
+			return nil, errors.Errorf("unsupported %s %q", MqttScheme, config.MqttScheme)
+		}
+		if isWebsocket(uri) {
+			uri.Path = config.MqttWebsocketPath
+		}
+		return []*url.URL{uri}, nil
+	}
+
+	brokers := make([]*url.URL, 0, len(config.MqttBrokers))
+	for _, broker := range config.MqttBrokers {
+		uri, err := url.Parse(broker)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid broker URI %q in %s", broker, MqttBrokers)
+		}
+		uri.Scheme = strings.ToLower(uri.Scheme)
+		if !brokerSchemes[uri.Scheme] {
+			return nil, errors.Errorf("unsupported scheme in broker URI %q in %s", broker, MqttBrokers)
+		}
+		if uri.Hostname() == "" || uri.Port() == "" {
+			return nil, errors.Errorf("broker URI %q in %s must have a host and port", broker, MqttBrokers)
+		}
+		if isWebsocket(uri) && uri.Path == "" {
+			uri.Path = config.MqttWebsocketPath
+		}
+		brokers = append(brokers, uri)
+	}
+	return brokers, nil
+}
+
+// mqttClient returns the client of the broker currently in use, or nil if
+// there hasn't been a connection attempt yet
+func (driver *Driver) mqttClient() brokerClient {
+	driver.clientMutex.RLock()
+	defer driver.clientMutex.RUnlock()
+	return driver.Client
+}
+
+// activeBroker returns the URI of the broker currently in use
+func (driver *Driver) activeBroker() *url.URL {
+	driver.clientMutex.RLock()
+	defer driver.clientMutex.RUnlock()
+	if driver.broker == nil {
+		return &url.URL{}
+	}
+	return driver.broker
+}
+
+// retireClient marks the client in use as being replaced. It returns false if the
+// client isn't in use or is already retired, so each client starts at most one
+// re-connection, whether its connection is lost or the driver fails back.
+func (driver *Driver) retireClient(client brokerClient) bool {
+	driver.clientMutex.Lock()
+	defer driver.clientMutex.Unlock()
+	if client != driver.Client || client == driver.retiredClient {
+		return false
+	}
+	driver.retiredClient = client
+	return true
+}
+
+// createClient creates an MQTT client for the broker based on the driver config
+// and makes it the client in use, but does not connect it yet. The MQTT 3.1.1
+// client reaches WebSocket brokers behind a proxy through a websocketBridge.
+func (driver *Driver) createClient(broker *url.URL) (brokerClient, error) {
+	server := broker
+	var dialer *websocketDialer
+	var bridge *websocketBridge
+	if isWebsocket(broker) {
+		var err error
+		if dialer, err = driver.newWebsocketDialer(broker); err != nil {
+			return nil, err
+		}
+		if driver.needsBridge(broker) {
+			if bridge, err = newWebsocketBridge(driver.Logger, dialer); err != nil {
+				return nil, err
+			}
+			server = bridge.address()
+		}
+	}
+
+	driver.Logger.Info("Create MQTT client", "uri", broker.String(),
+		"clientId", driver.Config.MqttClientId, "version", driver.Config.MqttProtocolVersion)
+
+	var client brokerClient
+	if driver.mqtt5 {
+		client = driver.newMqtt5Client(server, dialer)
+	} else {
+		client = driver.newMqtt311Client(server, dialer)
+	}
+
+	// when a session is resumed, the broker may send messages before the
+	// subscriptions are made again, so their routes must already exist
+	for _, s := range driver.subscriptions() {
+		client.AddRoute(s.filter, s.handler)
+	}
+
+	driver.clientMutex.Lock()
+	previous := driver.bridge
+	driver.Client = client
+	driver.broker = broker
+	driver.bridge = bridge
+	driver.clientMutex.Unlock()
+
+	if previous != nil {
+		previous.Close()
+	}
+	return client, nil
+}
+
+// newMqtt311Client creates a paho MQTT 3.1.1 client for the server based on the driver
+// config; the headers of the WebSocket dialer, if any, are sent by the mqtt library
+func (driver *Driver) newMqtt311Client(server *url.URL, dialer *websocketDialer) mqtt.Client {
+	opts := mqtt.NewClientOptions()
+
+	// use `append()` because `opts.AddBroker()` does superfluous url parsing
+	opts.Servers = append(opts.Servers, server)
+
+	opts.SetClientID(driver.Config.MqttClientId)
+	opts.SetUsername(driver.Config.MqttUser)
+	opts.SetPassword(driver.Config.MqttPassword)
+	opts.SetKeepAlive(time.Second * time.Duration(driver.Config.MqttKeepAlive))
+	opts.SetTLSConfig(driver.brokerTLSConfig(server))
+	if dialer != nil {
+		opts.SetHTTPHeaders(dialer.headers)
+	}
+	opts.SetAutoReconnect(driver.autoReconnect())
+	opts.SetMaxReconnectInterval(time.Duration(driver.Config.RetryMaxWaitSeconds) * time.Second)
+	opts.SetCleanSession(driver.Config.MqttCleanSession)
+	if driver.Config.MqttStoreDir != "" {
+		opts.SetStore(mqtt.NewFileStore(driver.Config.MqttStoreDir))
+	}
+
+	opts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
+		driver.onMqttConnectionLost(client, err)
+	})
+	opts.SetOnConnectHandler(func(client mqtt.Client) {
+		driver.onMqttConnect(client)
+	})
+
+	return mqtt.NewClient(opts)
+}
+
+// autoReconnect returns true if the mqtt library re-connects by itself. That's
+// the case with a single broker and MQTT 3.1.1, unless it needs a websocketBridge;
+// otherwise, connect is used to fail over or to create a new client.
+func (driver *Driver) autoReconnect() bool {
+	return len(driver.brokers) == 1 && !driver.mqtt5 && !driver.needsBridge(driver.brokers[0])
+}
+
+// closeBridge stops the websocketBridge of the client in use, if it has one
+func (driver *Driver) closeBridge() {
+	driver.clientMutex.Lock()
+	bridge := driver.bridge
+	driver.bridge = nil
+	driver.clientMutex.Unlock()
+
+	if bridge != nil {
+		bridge.Close()
+	}
+}
+
+// connect tries each broker in order until a connection is established, and
+// keeps trying, with a growing wait between the rounds, until it succeeds or done
+// is signaled. It's called for the initial connection and, unless the mqtt library
+// does it by itself (see autoReconnect), to re-connect or fail over when the
+// connection is lost; re-connecting waits before the first round too, so that
+// services which lost the same broker don't all come back at the same moment.
+func (driver *Driver) connect(reconnecting bool) {
+	driver.startWatchdog()
+	backoff := driver.Config.newBackoff()
+
+	for {
+		if reconnecting && !driver.waitToConnect(backoff) {
+			return
+		}
+		reconnecting = true
+
+		for _, broker := range driver.brokers {
+			client, err := driver.createClient(broker)
+			if err != nil {
+				driver.Logger.Error("unable to create mqtt client", "broker", broker.String(), "cause", err.Error())
+				continue
+			}
+
+			driver.Logger.Info("attempting to establish connection to mqtt broker...", "broker", broker.String())
+			token := client.Connect()
+			if token.Wait() && token.Error() == nil {
+				driver.Logger.Info("mqtt connection successful", "broker", broker.String())
+				driver.retries.connected()
+				return
+			}
+			driver.Logger.Error("unable to connect to mqtt broker", "broker", broker.String(), "cause", token.Error())
+
+			select {
+			case <-driver.done:
+				driver.Logger.Info("done signaled. stopping connection attempts")
+				return
+			default:
+			}
+		}
+	}
+}
+
+// waitToConnect waits for the next round of connection attempts;
+// it returns false if done is signaled in the meantime
+func (driver *Driver) waitToConnect(backoff *backoff) bool {
+	wait := backoff.next()
+	driver.retries.connectFailed(backoff.attempts, wait)
+	driver.Logger.Info("attempting to connect to mqtt broker again...", "wait", wait.String(), "retry", backoff.attempts)
+
+	select {
+	case <-time.After(wait):
+		return true
+	case <-driver.done:
+		driver.Logger.Info("done signaled. stopping connection attempts")
+		return false
+	}
+}
+
+// failBackPeriodically checks every MqttFailbackSeconds whether to fail back to
+// the first broker, until done is signaled
+func (driver *Driver) failBackPeriodically() {
+	ticker := time.NewTicker(time.Duration(driver.Config.MqttFailbackSeconds) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			driver.failBack()
+		case <-driver.done:
+			return
+		}
+	}
+}
+
+// failBack re-connects, trying the brokers in order, if the driver is connected
+// to a broker other than the first one and the first one accepts connections again
+func (driver *Driver) failBack() {
+	primary := driver.failbackBroker()
+	if primary == nil {
+		return
+	}
+
+	client := driver.mqttClient()
+	if !driver.retireClient(client) {
+		// the connection was lost in the meantime and is being replaced already
+		return
+	}
+
+	driver.Logger.Info("First MQTT broker is reachable again; failing back",
+		"broker", primary.String(), "standby", driver.activeBroker().String())
+	driver.setConnected(false)
+	client.Disconnect(disconnectQuiesceMillis)
+	driver.setHealth(healthReconnecting)
+	driver.connect(false)
+}
+
+// failbackBroker returns the first broker if the driver is connected to another
+// one and the first one accepts TCP connections, or nil otherwise
+func (driver *Driver) failbackBroker() *url.URL {
+	if len(driver.brokers) < 2 || !driver.isConnected() {
+		return nil
+	}
+	primary := driver.brokers[0]
+	if driver.activeBroker() == primary {
+		return nil
+	}
+
+	conn, err := net.DialTimeout("tcp", primary.Host, failbackProbeTimeout)
+	if err != nil {
+		driver.Logger.Debug("First MQTT broker is still unreachable", "broker", primary.String(), "cause", err.Error())
+		return nil
+	}
+	_ = conn.Close()
+	return primary
+}
@@ -0,0 +1,88 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package driver
+
+import (
+	"github.com/eclipse/paho.mqtt.golang"
+	"github.com/edgexfoundry/go-mod-core-contracts/clients/logger"
+	expect "github.com/intel/rsp-sw-toolkit-im-suite-expect"
+	"net"
+	"net/url"
+	"testing"
+)
+
+func TestMqttBrokers_single(t *testing.T) {
+	w := expect.WrapT(t)
+	config := &configuration{MqttScheme: "TCP", MqttHost: "mosquitto-server", MqttPort: "1883"}
+
+	brokers := w.ShouldHaveResult(config.mqttBrokers()).([]*url.URL)
+	w.StopOnMismatch().ShouldHaveLength(brokers, 1)
+	w.ShouldBeEqual(brokers[0].String(), "tcp://mosquitto-server:1883")
+
+	config.MqttScheme = "http"
+	w.As("bad scheme").ShouldHaveError(config.mqttBrokers())
+}
+
+func TestMqttBrokers_multiple(t *testing.T) {
+	w := expect.WrapT(t)
+	config := &configuration{
+		MqttScheme:  "tcp",
+		MqttHost:    "ignored",
+		MqttPort:    "1883",
+		MqttBrokers: []string{"ssl://primary:8883", "WSS://standby:443/mqtt", "tcp://10.0.0.3:1883"},
+	}
+
+	brokers := w.ShouldHaveResult(config.mqttBrokers()).([]*url.URL)
+	w.StopOnMismatch().ShouldHaveLength(brokers, 3)
+	w.ShouldBeEqual(brokers[0].String(), "ssl://primary:8883")
+	w.ShouldBeEqual(brokers[1].String(), "wss://standby:443/mqtt")
+	w.ShouldBeEqual(brokers[2].String(), "tcp://10.0.0.3:1883")
+
+	config.MqttBrokers = []string{"ssl://primary:8883", "mqtt://standby:1883"}
+	w.As("bad scheme").ShouldHaveError(config.mqttBrokers())
+	config.MqttBrokers = []string{"ssl://primary"}
+	w.As("no port").ShouldHaveError(config.mqttBrokers())
+	config.MqttBrokers = []string{"primary:1883"}
+	w.As("no scheme").ShouldHaveError(config.mqttBrokers())
+}
+
+func TestFailbackBroker(t *testing.T) {
+	w := expect.WrapT(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	w.StopOnMismatch().ShouldBeNil(err)
+	defer listener.Close()
+
+	primary := &url.URL{Scheme: "tcp", Host: listener.Addr().String()}
+	standby := &url.URL{Scheme: "tcp", Host: "standby:1883"}
+	driver := &Driver{
+		Logger:  logger.NewClient("test", false, "", "DEBUG"),
+		brokers: []*url.URL{primary, standby},
+		broker:  standby,
+	}
+
+	w.As("disconnected").ShouldBeNil(driver.failbackBroker())
+	driver.setConnected(true)
+	w.As("on standby").ShouldBeEqual(driver.failbackBroker(), primary)
+
+	driver.broker = primary
+	w.As("on primary").ShouldBeNil(driver.failbackBroker())
+
+	driver.broker = standby
+	w.ShouldSucceed(listener.Close())
+	w.As("primary down").ShouldBeNil(driver.failbackBroker())
+}
+
+func TestRetireClient(t *testing.T) {
+	w := expect.WrapT(t)
+	client := mqtt.NewClient(mqtt.NewClientOptions())
+	driver := &Driver{Client: client}
+
+	w.As("other client").ShouldBeFalse(driver.retireClient(mqtt.NewClient(mqtt.NewClientOptions())))
+	w.As("client in use").ShouldBeTrue(driver.retireClient(client))
+	w.As("retired client").ShouldBeFalse(driver.retireClient(client))
+}
@@ -0,0 +1,425 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package driver
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	sdkModel "github.com/edgexfoundry/device-sdk-go/pkg/models"
+	"github.com/edgexfoundry/go-mod-core-contracts/clients"
+	"github.com/edgexfoundry/go-mod-core-contracts/clients/logger"
+)
+
+const (
+	bufferFileExt = ".json"
+	bufferTmpExt  = ".tmp"
+
+	// corePingInterval is how often core-data is pinged, whether readings are sent
+	// directly or from the buffer, or while it's unreachable
+	corePingInterval = 5 * time.Second
+	corePingTimeout  = 5 * time.Second
+	// bufferSendWait is how long send waits for room in the channel before it
+	// buffers readings, so that short bursts don't go to disk
+	bufferSendWait = 100 * time.Millisecond
+)
+
+// readingBuffer is a disk backed FIFO of readings waiting to be sent to EdgeX.
+// The device SDK drops readings it can't send to core-data, so readings are
+// written to disk while core-data doesn't answer its ping, as well as while
+// EdgeX isn't keeping up, and they're replayed in the order they were received
+// while it does. Readings sent in the moments between core-data going down
+// and the next ping are still lost.
+type readingBuffer struct {
+	logger   logger.LoggingClient
+	dir      string
+	maxBytes int64
+	maxAge   time.Duration
+
+	// ping returns an error if core-data is unreachable
+	ping         func() error
+	pingInterval time.Duration
+	sendWait     time.Duration
+	// reachable is 1 while core-data answered its last ping, 0 while it didn't
+	// and -1 before the first one, accessed atomically
+	reachable int32
+
+	mu      sync.Mutex
+	entries []bufferEntry // oldest first
+	size    int64
+	nextSeq uint64
+
+	// ready is signaled when an entry is added
+	ready chan struct{}
+}
+
+// bufferEntry is a batch of readings stored in its own file
+type bufferEntry struct {
+	seq  uint64
+	size int64
+}
+
+// bufferedReadings is the stored form of an AsyncValues
+type bufferedReadings struct {
+	Created    int64           `json:"created"`
+	DeviceName string          `json:"device_name"`
+	Values     []bufferedValue `json:"values"`
+}
+
+// bufferedValue is the stored form of a CommandValue, whose string value isn't exported
+type bufferedValue struct {
+	DeviceResourceName string             `json:"name"`
+	Origin             int64              `json:"origin"`
+	Type               sdkModel.ValueType `json:"type"`
+	NumericValue       []byte             `json:"numeric,omitempty"`
+	StringValue        string             `json:"string,omitempty"`
+	BinValue           []byte             `json:"binary,omitempty"`
+}
+
+// pingCoreData returns a function which pings EdgeX core-data at its base URL
+func pingCoreData(coreDataURL string) func() error {
+	client := &http.Client{Timeout: corePingTimeout}
+	pingURL := strings.TrimSuffix(coreDataURL, "/") + clients.ApiPingRoute
+	return func() error {
+		resp, err := client.Get(pingURL)
+		if err != nil {
+			return err
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return errors.Errorf("ping returned %s", resp.Status)
+		}
+		return nil
+	}
+}
+
+// newReadingBuffer creates a buffer in dir, picking up any readings left there
+// by a previous run, and pings core-data with ping to find out if it's reachable.
+// If maxBytes or maxAge are not positive, they're unlimited.
+func newReadingBuffer(lc logger.LoggingClient, dir string, maxBytes int64, maxAge time.Duration, ping func() error) (*readingBuffer, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrapf(err, "unable to create reading buffer directory %q", dir)
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read reading buffer directory %q", dir)
+	}
+
+	b := &readingBuffer{
+		logger:   lc,
+		dir:      dir,
+		maxBytes: maxBytes,
+		maxAge:   maxAge,
+		ready:    make(chan struct{}, 1),
+
+		ping:         ping,
+		pingInterval: corePingInterval,
+		sendWait:     bufferSendWait,
+		reachable:    -1,
+	}
+	b.checkReachable()
+
+	for _, info := range files {
+		name := info.Name()
+		if info.IsDir() {
+			continue
+		}
+		if strings.HasSuffix(name, bufferTmpExt) {
+			// a write was interrupted before it completed
+			_ = os.Remove(filepath.Join(dir, name))
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, bufferFileExt), 10, 64)
+		if err != nil || !strings.HasSuffix(name, bufferFileExt) {
+			continue
+		}
+		b.entries = append(b.entries, bufferEntry{seq: seq, size: info.Size()})
+		b.size += info.Size()
+	}
+
+	sort.Slice(b.entries, func(i, j int) bool { return b.entries[i].seq < b.entries[j].seq })
+	if n := len(b.entries); n > 0 {
+		b.nextSeq = b.entries[n-1].seq + 1
+		b.signal()
+	}
+
+	return b, nil
+}
+
+// Len returns the number of batches of readings in the buffer
+func (b *readingBuffer) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.entries)
+}
+
+// send passes the readings directly to the channel if nothing is buffered and
+// core-data is reachable, waiting up to sendWait for room in it; otherwise, or if
+// there's no room, it adds them to the end of the buffer
+func (b *readingBuffer) send(ch chan<- *sdkModel.AsyncValues, av *sdkModel.AsyncValues) error {
+	if b.Len() == 0 && atomic.LoadInt32(&b.reachable) == 1 {
+		wait := time.NewTimer(b.sendWait)
+		defer wait.Stop()
+		select {
+		case ch <- av:
+			return nil
+		case <-wait.C:
+		}
+	}
+
+	return b.push(av)
+}
+
+// push writes the readings to a new file at the end of the buffer, then drops
+// the oldest entries until the buffer is within its size limit. The files are
+// written and removed without holding the lock, so other senders aren't held up.
+func (b *readingBuffer) push(av *sdkModel.AsyncValues) error {
+	stored := bufferedReadings{
+		Created:    time.Now().UnixNano(),
+		DeviceName: av.DeviceName,
+		Values:     make([]bufferedValue, 0, len(av.CommandValues)),
+	}
+	for _, cv := range av.CommandValues {
+		bv := bufferedValue{
+			DeviceResourceName: cv.DeviceResourceName,
+			Origin:             cv.Origin,
+			Type:               cv.Type,
+			NumericValue:       cv.NumericValue,
+			BinValue:           cv.BinValue,
+		}
+		if cv.Type == sdkModel.String {
+			bv.StringValue, _ = cv.StringValue()
+		}
+		stored.Values = append(stored.Values, bv)
+	}
+
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return errors.Wrap(err, "unable to marshal readings")
+	}
+
+	b.mu.Lock()
+	seq := b.nextSeq
+	b.nextSeq++
+	b.mu.Unlock()
+
+	// write to a temporary file first, so partial writes are never replayed
+	tmp := b.path(seq) + bufferTmpExt
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		_ = os.Remove(tmp)
+		return errors.Wrap(err, "unable to write buffered readings")
+	}
+	if err := os.Rename(tmp, b.path(seq)); err != nil {
+		_ = os.Remove(tmp)
+		return errors.Wrap(err, "unable to write buffered readings")
+	}
+
+	b.mu.Lock()
+	// another sender may have added a later entry while this one was written
+	i := sort.Search(len(b.entries), func(i int) bool { return b.entries[i].seq > seq })
+	b.entries = append(b.entries, bufferEntry{})
+	copy(b.entries[i+1:], b.entries[i:])
+	b.entries[i] = bufferEntry{seq: seq, size: int64(len(data))}
+	b.size += int64(len(data))
+
+	var dropped []bufferEntry
+	for b.maxBytes > 0 && b.size > b.maxBytes && len(b.entries) > 1 {
+		entry := b.entries[0]
+		b.removeLocked(entry)
+		dropped = append(dropped, entry)
+	}
+	b.mu.Unlock()
+
+	if len(dropped) > 0 {
+		b.deleteFiles(dropped...)
+		b.logger.Warn("Reading buffer is full; dropped oldest readings",
+			"batches", len(dropped), "maxBytes", b.maxBytes)
+	}
+
+	b.signal()
+	return nil
+}
+
+// peek returns the oldest readings in the buffer without removing them.
+// Entries older than maxAge or that can't be read are removed and skipped.
+func (b *readingBuffer) peek() (*sdkModel.AsyncValues, bufferEntry, bool) {
+	for {
+		b.mu.Lock()
+		if len(b.entries) == 0 {
+			b.mu.Unlock()
+			return nil, bufferEntry{}, false
+		}
+		entry := b.entries[0]
+		b.mu.Unlock()
+
+		av, created, err := b.read(entry)
+		if err != nil {
+			b.logger.Error("Dropping unreadable buffered readings", "seq", entry.seq, "cause", err.Error())
+			b.remove(entry)
+			continue
+		}
+		if b.maxAge > 0 && time.Since(created) > b.maxAge {
+			b.logger.Warn("Dropping expired buffered readings",
+				"device", av.DeviceName, "created", created, "maxAge", b.maxAge)
+			b.remove(entry)
+			continue
+		}
+		return av, entry, true
+	}
+}
+
+// read loads the readings of the entry from disk
+func (b *readingBuffer) read(entry bufferEntry) (*sdkModel.AsyncValues, time.Time, error) {
+	data, err := ioutil.ReadFile(b.path(entry.seq))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	var stored bufferedReadings
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, time.Time{}, err
+	}
+
+	av := &sdkModel.AsyncValues{
+		DeviceName:    stored.DeviceName,
+		CommandValues: make([]*sdkModel.CommandValue, 0, len(stored.Values)),
+	}
+	for _, bv := range stored.Values {
+		var cv *sdkModel.CommandValue
+		if bv.Type == sdkModel.String {
+			cv = sdkModel.NewStringValue(bv.DeviceResourceName, bv.Origin, bv.StringValue)
+		} else {
+			cv = &sdkModel.CommandValue{
+				DeviceResourceName: bv.DeviceResourceName,
+				Origin:             bv.Origin,
+				Type:               bv.Type,
+				NumericValue:       bv.NumericValue,
+				BinValue:           bv.BinValue,
+			}
+		}
+		av.CommandValues = append(av.CommandValues, cv)
+	}
+	return av, time.Unix(0, stored.Created), nil
+}
+
+// remove deletes the entry and its file if it's still in the buffer
+func (b *readingBuffer) remove(entry bufferEntry) {
+	b.mu.Lock()
+	removed := b.removeLocked(entry)
+	b.mu.Unlock()
+
+	if removed {
+		b.deleteFiles(entry)
+	}
+}
+
+// removeLocked takes the entry out of the buffer and returns true if it was
+// still in it; its file is left to deleteFiles. The caller must hold the lock.
+func (b *readingBuffer) removeLocked(entry bufferEntry) bool {
+	for i, e := range b.entries {
+		if e.seq != entry.seq {
+			continue
+		}
+		b.entries = append(b.entries[:i], b.entries[i+1:]...)
+		b.size -= e.size
+		return true
+	}
+	return false
+}
+
+// deleteFiles deletes the files of entries taken out of the buffer
+func (b *readingBuffer) deleteFiles(entries ...bufferEntry) {
+	for _, e := range entries {
+		if err := os.Remove(b.path(e.seq)); err != nil && !os.IsNotExist(err) {
+			b.logger.Warn("Unable to remove buffered readings", "seq", e.seq, "cause", err.Error())
+		}
+	}
+}
+
+// forward sends the buffered readings to the channel in order until done is
+// closed. They're kept in the buffer while core-data is unreachable, which is
+// checked at most every pingInterval, both while draining the buffer and while
+// it's empty, so that send starts buffering once core-data is unreachable.
+func (b *readingBuffer) forward(ch chan<- *sdkModel.AsyncValues, done <-chan interface{}) {
+	var lastPing time.Time
+	for {
+		if time.Since(lastPing) >= b.pingInterval {
+			b.checkReachable()
+			lastPing = time.Now()
+		}
+		if atomic.LoadInt32(&b.reachable) != 1 {
+			select {
+			case <-time.After(b.pingInterval - time.Since(lastPing)):
+				continue
+			case <-done:
+				return
+			}
+		}
+
+		av, entry, ok := b.peek()
+		if !ok {
+			select {
+			case <-b.ready:
+				continue
+			case <-time.After(b.pingInterval - time.Since(lastPing)):
+				continue
+			case <-done:
+				return
+			}
+		}
+
+		select {
+		case ch <- av:
+			// only remove it once it's sent, so new readings keep queuing behind it
+			b.remove(entry)
+		case <-done:
+			return
+		}
+	}
+}
+
+// checkReachable pings core-data and records whether it's reachable
+func (b *readingBuffer) checkReachable() bool {
+	err := b.ping()
+	reachable := int32(0)
+	if err == nil {
+		reachable = 1
+	}
+
+	previous := atomic.SwapInt32(&b.reachable, reachable)
+	switch {
+	case err != nil && previous != 0:
+		b.logger.Warn("EdgeX core-data is unreachable; buffering readings", "cause", err.Error())
+	case err == nil && previous == 0:
+		b.logger.Info("EdgeX core-data is reachable; sending buffered readings", "batches", b.Len())
+	}
+	return err == nil
+}
+
+// signal wakes up the forwarder, if it's waiting
+func (b *readingBuffer) signal() {
+	select {
+	case b.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (b *readingBuffer) path(seq uint64) string {
+	return filepath.Join(b.dir, fmt.Sprintf("%020d%s", seq, bufferFileExt))
+}
@@ -0,0 +1,322 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package driver
+
+import (
+	sdkModel "github.com/edgexfoundry/device-sdk-go/pkg/models"
+	"github.com/edgexfoundry/go-mod-core-contracts/clients"
+	"github.com/edgexfoundry/go-mod-core-contracts/clients/logger"
+	expect "github.com/intel/rsp-sw-toolkit-im-suite-expect"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestBuffer creates a buffer in a new temporary directory, which the caller must remove
+func newTestBuffer(t *testing.T, maxBytes int64, maxAge time.Duration) (*readingBuffer, string) {
+	dir, err := ioutil.TempDir("", "reading-buffer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := newReadingBuffer(logger.NewClient("test", false, "", "DEBUG"), dir, maxBytes, maxAge, pingReachable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.sendWait = time.Millisecond
+	return b, dir
+}
+
+// pingReachable is the ping of a core-data which is always reachable
+func pingReachable() error {
+	return nil
+}
+
+// fakeCoreData stands in for core-data, which is reachable while up is 1
+type fakeCoreData struct {
+	up    int32
+	pings int32
+}
+
+func (core *fakeCoreData) ping() error {
+	atomic.AddInt32(&core.pings, 1)
+	if atomic.LoadInt32(&core.up) == 1 {
+		return nil
+	}
+	return errors.New("connection refused")
+}
+
+// receive waits for readings on the channel, or fails the test after a second
+func receive(t *testing.T, ch <-chan *sdkModel.AsyncValues) *sdkModel.AsyncValues {
+	select {
+	case av := <-ch:
+		return av
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for readings")
+		return nil
+	}
+}
+
+func testReadings(i int) *sdkModel.AsyncValues {
+	rssi, _ := sdkModel.NewInt32Value("inventory_tag_rssi", int64(i), int32(-i))
+	return &sdkModel.AsyncValues{
+		DeviceName: "RSP-" + strconv.Itoa(i),
+		CommandValues: []*sdkModel.CommandValue{
+			sdkModel.NewStringValue("inventory_data", int64(i), `{"n":`+strconv.Itoa(i)+`}`),
+			rssi,
+		},
+	}
+}
+
+func TestReadingBuffer_SendsDirectlyWhenEmpty(t *testing.T) {
+	w := expect.WrapT(t)
+	b, dir := newTestBuffer(t, 0, 0)
+	defer os.RemoveAll(dir)
+
+	ch := make(chan *sdkModel.AsyncValues, 1)
+	w.ShouldSucceed(b.send(ch, testReadings(1)))
+	w.ShouldBeEqual(b.Len(), 0)
+	w.ShouldBeEqual((<-ch).DeviceName, "RSP-1")
+}
+
+func TestReadingBuffer_WaitsOutBursts(t *testing.T) {
+	w := expect.WrapT(t)
+	b, dir := newTestBuffer(t, 0, 0)
+	defer os.RemoveAll(dir)
+	b.sendWait = time.Second
+
+	// the channel is full only briefly
+	ch := make(chan *sdkModel.AsyncValues, 1)
+	ch <- testReadings(0)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		<-ch
+	}()
+
+	w.ShouldSucceed(b.send(ch, testReadings(1)))
+	w.ShouldBeEqual(b.Len(), 0)
+	w.ShouldBeEqual((<-ch).DeviceName, "RSP-1")
+}
+
+func TestReadingBuffer_ConcurrentPush(t *testing.T) {
+	w := expect.WrapT(t)
+	b, dir := newTestBuffer(t, 0, 0)
+	defer os.RemoveAll(dir)
+
+	ch := make(chan *sdkModel.AsyncValues)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			w.ShouldSucceed(b.send(ch, testReadings(i)))
+		}(i)
+	}
+	wg.Wait()
+	w.ShouldBeEqual(b.Len(), 20)
+
+	for i := 1; i < len(b.entries); i++ {
+		w.ShouldBeTrue(b.entries[i-1].seq < b.entries[i].seq)
+	}
+}
+
+func TestReadingBuffer_ReplaysInOrder(t *testing.T) {
+	w := expect.WrapT(t)
+	b, dir := newTestBuffer(t, 0, 0)
+	defer os.RemoveAll(dir)
+
+	// nothing is reading the channel, so everything is buffered
+	ch := make(chan *sdkModel.AsyncValues)
+	for i := 1; i <= 3; i++ {
+		w.ShouldSucceed(b.send(ch, testReadings(i)))
+	}
+	w.ShouldBeEqual(b.Len(), 3)
+
+	done := make(chan interface{})
+	defer close(done)
+	go b.forward(ch, done)
+
+	for i := 1; i <= 3; i++ {
+		av := <-ch
+		w.ShouldBeEqual(av.DeviceName, "RSP-"+strconv.Itoa(i))
+		w.StopOnMismatch().ShouldHaveLength(av.CommandValues, 2)
+
+		s, err := av.CommandValues[0].StringValue()
+		w.ShouldSucceed(err)
+		w.ShouldBeEqual(s, `{"n":`+strconv.Itoa(i)+`}`)
+		w.ShouldBeEqual(av.CommandValues[0].Origin, int64(i))
+
+		rssi, err := av.CommandValues[1].Int32Value()
+		w.ShouldSucceed(err)
+		w.ShouldBeEqual(rssi, int32(-i))
+	}
+}
+
+func TestReadingBuffer_QueuesBehindBuffered(t *testing.T) {
+	w := expect.WrapT(t)
+	b, dir := newTestBuffer(t, 0, 0)
+	defer os.RemoveAll(dir)
+
+	ch := make(chan *sdkModel.AsyncValues, 2)
+	ch <- testReadings(0)
+	ch <- testReadings(0)
+	w.ShouldSucceed(b.send(ch, testReadings(1)))
+	<-ch
+
+	// there's room in the channel now, but the new readings must wait their turn
+	w.ShouldSucceed(b.send(ch, testReadings(2)))
+	w.ShouldBeEqual(b.Len(), 2)
+}
+
+func TestReadingBuffer_Reload(t *testing.T) {
+	w := expect.WrapT(t)
+	b, dir := newTestBuffer(t, 0, 0)
+	defer os.RemoveAll(dir)
+
+	ch := make(chan *sdkModel.AsyncValues)
+	w.ShouldSucceed(b.send(ch, testReadings(1)))
+	w.ShouldSucceed(b.send(ch, testReadings(2)))
+	w.ShouldSucceed(ioutil.WriteFile(b.path(2)+bufferTmpExt, []byte("{"), 0600))
+
+	reloaded := w.ShouldHaveResult(newReadingBuffer(b.logger, dir, 0, 0, pingReachable)).(*readingBuffer)
+	w.ShouldBeEqual(reloaded.Len(), 2)
+	w.ShouldBeEqual(reloaded.nextSeq, uint64(2))
+
+	av, _, ok := reloaded.peek()
+	w.StopOnMismatch().ShouldBeTrue(ok)
+	w.ShouldBeEqual(av.DeviceName, "RSP-1")
+
+	_, err := os.Stat(b.path(2) + bufferTmpExt)
+	w.ShouldBeTrue(os.IsNotExist(err))
+}
+
+func TestReadingBuffer_MaxBytes(t *testing.T) {
+	w := expect.WrapT(t)
+	b, dir := newTestBuffer(t, 0, 0)
+	defer os.RemoveAll(dir)
+
+	ch := make(chan *sdkModel.AsyncValues)
+	w.ShouldSucceed(b.send(ch, testReadings(1)))
+	b.maxBytes = b.size * 2
+
+	for i := 2; i <= 4; i++ {
+		w.ShouldSucceed(b.send(ch, testReadings(i)))
+	}
+	w.ShouldBeEqual(b.Len(), 2)
+
+	av, _, ok := b.peek()
+	w.StopOnMismatch().ShouldBeTrue(ok)
+	w.ShouldBeEqual(av.DeviceName, "RSP-3")
+}
+
+func TestReadingBuffer_MaxAge(t *testing.T) {
+	w := expect.WrapT(t)
+	b, dir := newTestBuffer(t, 0, time.Hour)
+	defer os.RemoveAll(dir)
+
+	ch := make(chan *sdkModel.AsyncValues)
+	w.ShouldSucceed(b.send(ch, testReadings(1)))
+	w.ShouldSucceed(b.send(ch, testReadings(2)))
+
+	// pretend the first readings were buffered long ago
+	old := []byte(`{"created":1,"device_name":"RSP-1","values":[]}`)
+	w.ShouldSucceed(ioutil.WriteFile(b.path(0), old, 0600))
+
+	av, _, ok := b.peek()
+	w.StopOnMismatch().ShouldBeTrue(ok)
+	w.ShouldBeEqual(av.DeviceName, "RSP-2")
+	w.ShouldBeEqual(b.Len(), 1)
+}
+
+func TestReadingBuffer_CoreDataUnavailable(t *testing.T) {
+	w := expect.WrapT(t)
+	dir, err := ioutil.TempDir("", "reading-buffer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	core := &fakeCoreData{}
+	b := w.ShouldHaveResult(newReadingBuffer(logger.NewClient("test", false, "", "DEBUG"), dir, 0, 0, core.ping)).(*readingBuffer)
+	b.pingInterval = 10 * time.Millisecond
+
+	// there's room in the channel, but the SDK would drop the readings
+	ch := make(chan *sdkModel.AsyncValues, 3)
+	w.ShouldSucceed(b.send(ch, testReadings(1)))
+	w.ShouldSucceed(b.send(ch, testReadings(2)))
+	w.ShouldBeEqual(b.Len(), 2)
+
+	done := make(chan interface{})
+	defer close(done)
+	go b.forward(ch, done)
+
+	time.Sleep(50 * time.Millisecond)
+	w.As("while core-data is down").ShouldHaveLength(ch, 0)
+	w.ShouldBeEqual(b.Len(), 2)
+
+	atomic.StoreInt32(&core.up, 1)
+	w.ShouldBeEqual(receive(t, ch).DeviceName, "RSP-1")
+	w.ShouldBeEqual(receive(t, ch).DeviceName, "RSP-2")
+
+	// once core-data goes down again, new readings are buffered
+	atomic.StoreInt32(&core.up, 0)
+	for atomic.LoadInt32(&b.reachable) != 0 {
+		time.Sleep(b.pingInterval)
+	}
+	w.ShouldSucceed(b.send(ch, testReadings(3)))
+	w.ShouldHaveLength(ch, 0)
+	w.ShouldBeEqual(b.Len(), 1)
+}
+
+func TestPingCoreData(t *testing.T) {
+	w := expect.WrapT(t)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != clients.ApiPingRoute {
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	w.ShouldSucceed(pingCoreData(server.URL + "/")())
+	w.As("wrong path").ShouldFail(pingCoreData(server.URL + "/core")())
+
+	server.Close()
+	w.As("down").ShouldFail(pingCoreData(server.URL)())
+}
+
+func TestReadingBuffer_PingsOncePerInterval(t *testing.T) {
+	w := expect.WrapT(t)
+	dir, err := ioutil.TempDir("", "reading-buffer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	core := &fakeCoreData{up: 1}
+	b := w.ShouldHaveResult(newReadingBuffer(logger.NewClient("test", false, "", "DEBUG"), dir, 0, 0, core.ping)).(*readingBuffer)
+	b.sendWait = time.Millisecond
+	w.ShouldBeEqual(atomic.LoadInt32(&core.pings), int32(1))
+
+	ch := make(chan *sdkModel.AsyncValues)
+	for i := 1; i <= 5; i++ {
+		w.ShouldSucceed(b.send(ch, testReadings(i)))
+	}
+
+	done := make(chan interface{})
+	defer close(done)
+	go b.forward(ch, done)
+	for i := 1; i <= 5; i++ {
+		w.ShouldBeEqual(receive(t, ch).DeviceName, "RSP-"+strconv.Itoa(i))
+	}
+	w.As("draining").ShouldBeEqual(atomic.LoadInt32(&core.pings), int32(2))
+}
@@ -9,9 +9,9 @@ package driver
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/eclipse/paho.golang/paho"
 	"github.com/intel/rsp-sw-toolkit-im-suite-mqtt-device-service/internal/jsonrpc"
 	"github.com/pkg/errors"
-	"strings"
 	"time"
 
 	sdkModel "github.com/edgexfoundry/device-sdk-go/pkg/models"
@@ -19,7 +19,15 @@ import (
 )
 
 const (
-	RSPPrefix = "RSP"
+	// methodAttribute is an optional device resource attribute naming the
+	// jsonrpc method to call; it defaults to the device resource name
+	methodAttribute = "method"
+	// paramsAttribute is an optional device resource attribute holding a JSON
+	// object to send as the params of the jsonrpc request
+	paramsAttribute = "params"
+	// readingOnlyAttribute marks device resources which only hold readings of
+	// notifications, when set to "true"; they can't be read with commands
+	readingOnlyAttribute = "readingOnly"
 )
 
 // HandleReadCommands is the entrypoint for a command from EdgeX command service
@@ -29,8 +37,23 @@ func (driver *Driver) HandleReadCommands(deviceName string, protocols map[string
 	var responses = make([]*sdkModel.CommandValue, len(reqs))
 	var err error
 
+	for _, req := range reqs {
+		if isReadingOnly(req) {
+			err = errors.Errorf("%q only holds readings of notifications and can't be read", req.DeviceResourceName)
+			driver.Logger.Warn("Handle read commands failed", "cause", err)
+			return responses, err
+		}
+	}
+
+	controller, err := driver.deviceController(deviceName, protocols)
+	if err != nil {
+		driver.Logger.Warn("Handle read commands failed", "cause", err)
+		return responses, err
+	}
+
+	sensorId := driver.sensorDeviceId(deviceName, protocols)
 	for i, req := range reqs {
-		res, err := driver.handleReadCommandRequest(deviceName, req)
+		res, err := driver.handleReadCommandRequest(controller, sensorId, req)
 		if err != nil {
 			driver.Logger.Warn("Handle read commands failed", "cause", err)
 			return responses, err
@@ -43,31 +66,63 @@ func (driver *Driver) HandleReadCommands(deviceName string, protocols map[string
 }
 
 // handleReadCommandRequest is the internal code to send commands over mqtt to
-// the rsp controller
-func (driver *Driver) handleReadCommandRequest(deviceName string, req sdkModel.CommandRequest) (*sdkModel.CommandValue, error) {
-	method := req.DeviceResourceName
-	var request jsonrpc.Message
-	var requestId string
-
-	// Sensor devices start with "RSP", this will not be needed in near future as Edgex is going to support GET requests with query parameters
-	// If the device is sensor add the device_id as params to the command request
-	if strings.HasPrefix(deviceName, RSPPrefix) {
-		req := jsonrpc.NewRSPCommandRequest(method, deviceName)
-		request, requestId = req, req.Id
-	} else {
-		req := jsonrpc.NewRequest(method)
-		request, requestId = req, req.Id
+// the rsp controller. sensorId is the device_id of the targeted sensor, if any.
+func (driver *Driver) handleReadCommandRequest(controller *rspController, sensorId string, req sdkModel.CommandRequest) (*sdkModel.CommandValue, error) {
+	method := commandMethod(req)
+
+	// query parameters aren't supported, since the device SDK doesn't pass them
+	// to the driver, so any params of a read command come from the attributes
+	// of its device resource
+	params, err := attributeParams(req)
+	if err != nil {
+		return nil, err
+	}
+
+	requestParams, err := buildCommandParams(sensorId, params)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid params for %q", req.DeviceResourceName)
+	}
+
+	request := jsonrpc.NewRequestWithParams(method, requestParams)
+	response, err := driver.sendCommand(controller, request, request.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	// format a response object for sending back to EdgeX
+	return driver.createEdgeXResponse(method, req.DeviceResourceName, response)
+}
+
+// pendingCommand is a command waiting for its response from the rsp controller
+type pendingCommand struct {
+	controller *rspController
+	response   chan *jsonrpc.Response
+	failed     chan error
+}
+
+// sendCommand publishes the request to the rsp controller and waits for the
+// response with the matching id, the configured timeout, or the command to be failed
+func (driver *Driver) sendCommand(controller *rspController, request jsonrpc.Message, requestId string) (*jsonrpc.Response, error) {
+	select {
+	case <-driver.done:
+		return nil, errors.New("the device service is stopping; command not sent")
+	default:
+	}
+
+	if driver.isControllerSilent(controller) {
+		return nil, errors.Errorf("RSP Controller %s stopped sending heartbeats; command not sent", controller.DeviceName)
 	}
 
-	responseChan := make(chan *jsonrpc.Response)
-	driver.responseMap.Store(requestId, responseChan)
-	// cleanup
-	defer func() {
-		driver.responseMap.Delete(requestId)
-		close(responseChan)
-	}()
+	// buffered so neither the response listener nor failCommands ever block
+	pending := &pendingCommand{
+		controller: controller,
+		response:   make(chan *jsonrpc.Response, 1),
+		failed:     make(chan error, 1),
+	}
+	driver.responseMap.Store(requestId, pending)
+	defer driver.responseMap.Delete(requestId)
 
-	if err := driver.publishCommand(request); err != nil {
+	if err := driver.publishCommand(controller, request, requestId); err != nil {
 		return nil, err
 	}
 
@@ -75,35 +130,197 @@ func (driver *Driver) handleReadCommandRequest(deviceName string, req sdkModel.C
 	defer timeout.Stop()
 
 	// wait for either the response or a timeout
-	for {
+	select {
+	case response := <-pending.response:
+		return response, nil
+	case <-timeout.C:
+		return nil, fmt.Errorf("timed out waiting for command response for request: %+v", request)
+	case err := <-pending.failed:
+		return nil, errors.Wrapf(err, "command %s failed", requestId)
+	}
+}
+
+// failCommands makes the commands of the controller waiting for a response
+// return the error; if the controller is nil, all of them do
+func (driver *Driver) failCommands(controller *rspController, err error) {
+	driver.responseMap.Range(func(key, value interface{}) bool {
+		pending := value.(*pendingCommand)
+		if controller != nil && pending.controller != controller {
+			return true
+		}
 		select {
-		case response := <-responseChan:
-			if response.Id == requestId {
-				// if these are the droids we are looking for, format a response object for sending back to EdgeX
-				return driver.createEdgeXResponse(req.DeviceResourceName, response)
-			}
-		case <-timeout.C:
-			return nil, fmt.Errorf("timed out waiting for command response for request: %+v", request)
-		case <-driver.done:
-			return nil, errors.New("done signaled. ignoring response")
+		case pending.failed <- err:
+			driver.Logger.Warn("Failed command waiting for a response", "requestId", key, "cause", err.Error())
+		default:
 		}
-	}
+		return true
+	})
 }
 
-func (driver *Driver) publishCommand(request jsonrpc.Message) error {
+// publishCommand publishes the request to the rsp controller. With MQTT 5, the
+// instanceResponseTopic and the requestId as correlation data are sent along, and the
+// request expires once the driver stops waiting for its response.
+func (driver *Driver) publishCommand(controller *rspController, request jsonrpc.Message, requestId string) error {
 	// marshal request to jsonrpc format
 	requestBytes, err := json.Marshal(request)
 	if err != nil {
 		return errors.Wrap(err, "marshalling of command request failed")
 	}
 
+	client := driver.mqttClient()
+	if client == nil {
+		return errors.New("not connected to an mqtt broker")
+	}
+
 	// Publish the command request
-	driver.Logger.Info("Publish command", "command", string(requestBytes))
-	driver.Client.Publish(driver.Config.CommandTopic, driver.Config.CommandQos, notRetained, requestBytes)
+	driver.Logger.Info("Publish command", "controller", controller.DeviceName, "command", string(requestBytes))
+	if client5, ok := client.(*mqtt5Client); ok {
+		expiry := uint32(driver.Config.MaxWaitTimeForReq)
+		client5.publishRequest(controller.CommandTopic, driver.Config.CommandQos, requestBytes, &paho.PublishProperties{
+			ResponseTopic:   driver.instanceResponseTopic(controller),
+			CorrelationData: []byte(requestId),
+			MessageExpiry:   &expiry,
+		})
+		return nil
+	}
+	client.Publish(controller.CommandTopic, driver.Config.CommandQos, notRetained, requestBytes)
 	return nil
 }
 
-// HandleWriteCommands ignores all requests; write commands (PUT requests) are not currently supported.
+// HandleWriteCommands is the entrypoint for a PUT command from EdgeX command service.
+// Each CommandValue holds the JSON object to send as the params of the matching
+// request; the command is sent via mqtt to the rsp controller and its response is
+// validated, but only errors are given back to EdgeX.
 func (driver *Driver) HandleWriteCommands(deviceName string, protocols map[string]models.ProtocolProperties, reqs []sdkModel.CommandRequest, params []*sdkModel.CommandValue) error {
+	if len(reqs) != len(params) {
+		return errors.Errorf("mismatched write command requests (%d) and params (%d)", len(reqs), len(params))
+	}
+
+	controller, err := driver.deviceController(deviceName, protocols)
+	if err != nil {
+		driver.Logger.Warn("Handle write commands failed", "cause", err)
+		return err
+	}
+
+	sensorId := driver.sensorDeviceId(deviceName, protocols)
+	for i, req := range reqs {
+		if err := driver.handleWriteCommandRequest(controller, sensorId, req, params[i]); err != nil {
+			driver.Logger.Warn("Handle write commands failed", "cause", err)
+			return err
+		}
+	}
+
 	return nil
 }
+
+// handleWriteCommandRequest is the internal code to send write commands over
+// mqtt to the rsp controller. sensorId is the device_id of the targeted sensor, if any.
+func (driver *Driver) handleWriteCommandRequest(controller *rspController, sensorId string, req sdkModel.CommandRequest, param *sdkModel.CommandValue) error {
+	method := commandMethod(req)
+
+	params, err := writeParams(req, param)
+	if err != nil {
+		return errors.Wrapf(err, "invalid params for %q", req.DeviceResourceName)
+	}
+
+	requestParams, err := buildCommandParams(sensorId, params)
+	if err != nil {
+		return errors.Wrapf(err, "invalid params for %q", req.DeviceResourceName)
+	}
+
+	request := jsonrpc.NewRequestWithParams(method, requestParams)
+	response, err := driver.sendCommand(controller, request, request.Id)
+	if err != nil {
+		return err
+	}
+
+	// the result is validated against its schema, but EdgeX has no use for it
+	_, err = driver.createEdgeXResponse(method, req.DeviceResourceName, response)
+	return err
+}
+
+// isReadingOnly returns true if the request is for a device resource which only
+// holds readings of notifications, rather than a command of the RSP Controller
+func isReadingOnly(req sdkModel.CommandRequest) bool {
+	return req.Attributes[readingOnlyAttribute] == "true" || req.Attributes[notificationAttribute] != ""
+}
+
+// commandMethod returns the jsonrpc method to call for the request
+func commandMethod(req sdkModel.CommandRequest) string {
+	if method := req.Attributes[methodAttribute]; method != "" {
+		return method
+	}
+	return req.DeviceResourceName
+}
+
+// attributeParams returns the params defined by the device resource attributes,
+// or nil if there are none
+func attributeParams(req sdkModel.CommandRequest) (jsonrpc.Parameters, error) {
+	value, ok := req.Attributes[paramsAttribute]
+	if !ok || value == "" {
+		return nil, nil
+	}
+
+	var params jsonrpc.Parameters
+	if err := json.Unmarshal([]byte(value), &params); err != nil {
+		return nil, errors.Wrapf(err, "%q attribute of %q must be a JSON object",
+			paramsAttribute, req.DeviceResourceName)
+	}
+	return params, nil
+}
+
+// writeParams merges the value of a write command, which must be a JSON object,
+// over the params defined by the device resource attributes
+func writeParams(req sdkModel.CommandRequest, param *sdkModel.CommandValue) (jsonrpc.Parameters, error) {
+	if param == nil {
+		return nil, errors.New("missing command value")
+	}
+
+	var value string
+	if param.Type == sdkModel.String {
+		var err error
+		if value, err = param.StringValue(); err != nil {
+			return nil, err
+		}
+	} else {
+		value = param.ValueToString()
+	}
+
+	var written jsonrpc.Parameters
+	if err := json.Unmarshal([]byte(value), &written); err != nil {
+		return nil, errors.Wrap(err, "command value must be a JSON object")
+	}
+
+	params, err := attributeParams(req)
+	if err != nil {
+		return nil, err
+	}
+	if params == nil {
+		params = jsonrpc.Parameters{}
+	}
+	for key, val := range written {
+		params[key] = val
+	}
+	return params, nil
+}
+
+// buildCommandParams marshals the params for a jsonrpc request. If the command
+// targets a sensor, its device_id is added to the params unless one is already
+// present. If there are no params for a non-sensor device, this returns nil.
+func buildCommandParams(sensorId string, params jsonrpc.Parameters) (json.RawMessage, error) {
+	if sensorId != "" {
+		if params == nil {
+			params = jsonrpc.Parameters{}
+		}
+		if _, ok := params[deviceIdKey]; !ok {
+			if err := params.Set(deviceIdKey, sensorId); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if params == nil {
+		return nil, nil
+	}
+	return json.Marshal(params)
+}
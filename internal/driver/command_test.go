@@ -0,0 +1,207 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package driver
+
+import (
+	"encoding/json"
+	"github.com/eclipse/paho.golang/paho"
+	"github.com/eclipse/paho.mqtt.golang"
+	sdkModel "github.com/edgexfoundry/device-sdk-go/pkg/models"
+	"github.com/edgexfoundry/go-mod-core-contracts/clients/logger"
+	expect "github.com/intel/rsp-sw-toolkit-im-suite-expect"
+	"github.com/intel/rsp-sw-toolkit-im-suite-mqtt-device-service/internal/jsonrpc"
+	"github.com/pkg/errors"
+	"testing"
+	"time"
+)
+
+func TestCommandMethod(t *testing.T) {
+	w := expect.WrapT(t)
+	w.ShouldBeEqual(commandMethod(sdkModel.CommandRequest{
+		DeviceResourceName: "behavior_get_all",
+	}), "behavior_get_all")
+	w.ShouldBeEqual(commandMethod(sdkModel.CommandRequest{
+		DeviceResourceName: "behavior_get_default",
+		Attributes:         map[string]string{methodAttribute: "behavior_get"},
+	}), "behavior_get")
+}
+
+func TestReadParams(t *testing.T) {
+	w := expect.WrapT(t)
+
+	req := sdkModel.CommandRequest{DeviceResourceName: "behavior_get_all"}
+	params := w.ShouldHaveResult(attributeParams(req)).(jsonrpc.Parameters)
+	w.As("no attributes").ShouldBeNil(params)
+	raw := w.ShouldHaveResult(buildCommandParams("", params)).(json.RawMessage)
+	w.As("controller without params").ShouldBeNil(raw)
+	raw = w.ShouldHaveResult(buildCommandParams("RSP-15077a", params)).(json.RawMessage)
+	w.As("sensor without params").ShouldBeEqual(string(raw), `{"device_id":"RSP-15077a"}`)
+
+	req.Attributes = map[string]string{paramsAttribute: `{"id":"default"}`}
+	params = w.ShouldHaveResult(attributeParams(req)).(jsonrpc.Parameters)
+	raw = w.ShouldHaveResult(buildCommandParams("", params)).(json.RawMessage)
+	w.As("controller with params").ShouldBeEqual(string(raw), `{"id":"default"}`)
+	raw = w.ShouldHaveResult(buildCommandParams("RSP-15077a", params)).(json.RawMessage)
+	w.As("sensor with params").ShouldBeEqual(string(raw), `{"device_id":"RSP-15077a","id":"default"}`)
+
+	req.Attributes = map[string]string{paramsAttribute: `[1, 2]`}
+	w.As("not an object").ShouldHaveError(attributeParams(req))
+}
+
+func TestWriteParams(t *testing.T) {
+	w := expect.WrapT(t)
+	build := func(sensorId string, req sdkModel.CommandRequest, cv *sdkModel.CommandValue) string {
+		params := w.StopOnMismatch().ShouldHaveResult(writeParams(req, cv)).(jsonrpc.Parameters)
+		return string(w.StopOnMismatch().ShouldHaveResult(
+			buildCommandParams(sensorId, params)).(json.RawMessage))
+	}
+
+	req := sdkModel.CommandRequest{DeviceResourceName: "behavior_put"}
+	cv := sdkModel.NewStringValue("behavior_put", 0, `{"id":"b1","power_level":30.5}`)
+	w.As("controller").ShouldBeEqual(build("", req, cv),
+		`{"id":"b1","power_level":30.5}`)
+
+	req = sdkModel.CommandRequest{DeviceResourceName: "sensor_set_facility"}
+	cv = sdkModel.NewStringValue("sensor_set_facility", 0, `{"facility_id":"f1"}`)
+	w.As("sensor").ShouldBeEqual(build("RSP-15077a", req, cv),
+		`{"device_id":"RSP-15077a","facility_id":"f1"}`)
+
+	cv = sdkModel.NewStringValue("sensor_set_facility", 0, `{"device_id":"RSP-other"}`)
+	w.As("explicit device_id").ShouldBeEqual(build("RSP-15077a", req, cv),
+		`{"device_id":"RSP-other"}`)
+
+	req = sdkModel.CommandRequest{DeviceResourceName: "behavior_put"}
+	cv = sdkModel.NewStringValue("behavior_put", 0, `null`)
+	w.As("null").ShouldBeEqual(build("", req, cv), `{}`)
+
+	req.Attributes = map[string]string{paramsAttribute: `{"id":"b1","power_level":10}`}
+	cv = sdkModel.NewStringValue("behavior_put", 0, `{"power_level":30.5}`)
+	w.As("merged with attributes").ShouldBeEqual(build("", req, cv),
+		`{"id":"b1","power_level":30.5}`)
+
+	w.As("not an object").ShouldHaveError(writeParams(req,
+		sdkModel.NewStringValue("behavior_put", 0, `["a"]`)))
+	w.As("not json").ShouldHaveError(writeParams(req,
+		sdkModel.NewStringValue("behavior_put", 0, `id: 1`)))
+	w.As("nil value").ShouldHaveError(writeParams(req, nil))
+}
+
+func newCommandTestDriver() *Driver {
+	return &Driver{
+		Logger: logger.NewClient("test", false, "", "DEBUG"),
+		Config: &configuration{MaxWaitTimeForReq: 10},
+		Client: mqtt.NewClient(mqtt.NewClientOptions()),
+		done:   make(chan interface{}),
+	}
+}
+
+// sendTestCommand sends a command in the background and waits until it's pending
+func sendTestCommand(d *Driver, requestId string) <-chan error {
+	return sendTestControllerCommand(d, &rspController{}, requestId)
+}
+
+// sendTestControllerCommand sends a command to the controller in the background and waits until it's pending
+func sendTestControllerCommand(d *Driver, controller *rspController, requestId string) <-chan error {
+	result := make(chan error, 1)
+	go func() {
+		_, err := d.sendCommand(controller, jsonrpc.NewRequest("behavior_get_all"), requestId)
+		result <- err
+	}()
+	for {
+		if _, ok := d.responseMap.Load(requestId); ok {
+			return result
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSendCommand_Response(t *testing.T) {
+	w := expect.WrapT(t)
+	d := newCommandTestDriver()
+
+	result := sendTestCommand(d, "1")
+	response := testMessage(`{"jsonrpc":"2.0","id":"1","result":[]}`)
+	d.onCommandResponseReceived(response)
+	// a duplicate must not block the response listener
+	d.onCommandResponseReceived(response)
+	w.ShouldSucceed(<-result)
+}
+
+func TestSendCommand_CorrelationData(t *testing.T) {
+	w := expect.WrapT(t)
+	d := newCommandTestDriver()
+
+	result := sendTestCommand(d, "1")
+	d.onCommandResponseReceived(controllerMessage{Message: &mqtt5Message{publish: &paho.Publish{
+		Payload:    []byte(`{"jsonrpc":"2.0","id":"other","result":[]}`),
+		Properties: &paho.PublishProperties{CorrelationData: []byte("1")},
+	}}})
+	w.ShouldSucceed(<-result)
+}
+
+func TestSendCommand_Failed(t *testing.T) {
+	w := expect.WrapT(t)
+	d := newCommandTestDriver()
+
+	result := sendTestCommand(d, "1")
+	d.failCommands(nil, errors.New("the device service is stopping"))
+	err := <-result
+	w.StopOnMismatch().ShouldNotBeNil(err)
+	w.ShouldContainStr(err.Error(), "stopping")
+
+	_, ok := d.responseMap.Load("1")
+	w.ShouldBeFalse(ok)
+
+	close(d.done)
+	w.As("after done").ShouldHaveError(d.sendCommand(&rspController{}, jsonrpc.NewRequest("behavior_get_all"), "2"))
+}
+
+func TestSendCommand_SilentController(t *testing.T) {
+	w := expect.WrapT(t)
+	d := newCommandTestDriver()
+	silent := &rspController{DeviceName: "rsp-controller-1"}
+	alive := &rspController{DeviceName: "rsp-controller-2"}
+	d.controllerHeartbeats.heartbeat(silent.DeviceName, silent, time.Now().Add(-time.Hour))
+
+	silentResult := sendTestControllerCommand(d, silent, "1")
+	aliveResult := sendTestControllerCommand(d, alive, "2")
+
+	// only the commands of the silent controller fail
+	d.onSilentControllers(d.controllerHeartbeats.silence(time.Now()))
+	err := <-silentResult
+	w.StopOnMismatch().ShouldNotBeNil(err)
+	w.ShouldContainStr(err.Error(), "stopped sending heartbeats")
+	_, ok := d.responseMap.Load("2")
+	w.ShouldBeTrue(ok)
+
+	d.onCommandResponseReceived(testMessage(`{"jsonrpc":"2.0","id":"2","result":[]}`))
+	w.ShouldSucceed(<-aliveResult)
+
+	// new commands fail right away until the heartbeats resume
+	w.ShouldHaveError(d.sendCommand(silent, jsonrpc.NewRequest("behavior_get_all"), "3"))
+	_, ok = d.responseMap.Load("3")
+	w.ShouldBeFalse(ok)
+}
+
+func TestHandleReadCommands_ReadingOnly(t *testing.T) {
+	w := expect.WrapT(t)
+	d := newCommandTestDriver()
+	d.controllers = []*rspController{{Id: "a", DeviceName: "rsp-controller"}}
+
+	read := func(attributes map[string]string) error {
+		_, err := d.HandleReadCommands("rsp-controller", nil, []sdkModel.CommandRequest{
+			{DeviceResourceName: "inventory_tag_epc", Attributes: attributes}})
+		return err
+	}
+	err := read(map[string]string{"name": "inventory_tag_epc", readingOnlyAttribute: "true"})
+	w.As("reading only").StopOnMismatch().ShouldNotBeNil(err)
+	w.ShouldContainStr(err.Error(), "can't be read")
+
+	err = read(map[string]string{notificationAttribute: "heartbeat", pathAttribute: "params.sent_on"})
+	w.As("typed reading").StopOnMismatch().ShouldNotBeNil(err)
+	w.ShouldContainStr(err.Error(), "can't be read")
+}
@@ -16,6 +16,7 @@ import (
 	"fmt"
 	"github.com/google/uuid"
 	"math/rand"
+	"os"
 	"reflect"
 	"regexp"
 	"strconv"
@@ -28,24 +29,94 @@ const (
 )
 
 var (
-	templateRegex = regexp.MustCompile("{{ *(random|uuid|epoch|millis|nanos)[_ ]*\\(?([0-9]+)?\\)? *}}")
+	templateRegex = regexp.MustCompile("{{ *(random|uuid|epoch|millis|nanos|hostname)[_ ]*\\(?([0-9]+)?\\)? *}}")
 	runes         = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789")
 )
 
 // configuration holds the values for the device configuration, including what
 // MQTT broker to connect to for incoming data and command responses.
+// Every property is required, except those with a default tag, which is the
+// value used when the property is missing.
 type configuration struct {
 	// ControllerName is the device name used for sending data received on the IncomingTopics into Edgex
 	ControllerName string
+	// ControllerIds lists the RSP Controllers served by this service. Each id replaces
+	// the "{controller}" segment of the ControllerName and topics to build the device
+	// name and topics of that controller. If empty, a single controller is served.
+	ControllerIds []string `default:""`
 	// MaxWaitTimeForReq is the maximum wait time in seconds for a command request to time out
 	MaxWaitTimeForReq int
-	// MaxReconnectWaitSeconds is the maximum amount of time to wait for connection/re-connection to mqtt broker before we panic()
+	// MaxReconnectWaitSeconds is the maximum amount of time to wait for connection/re-connection
+	// to mqtt broker before the service stops with a failure
 	MaxReconnectWaitSeconds int
+	// ShutdownWaitSeconds is the maximum amount of time to wait for received messages
+	// to be processed when the service stops
+	ShutdownWaitSeconds int `default:"10"`
+	// RetryInitialWaitMillis is the wait before retrying to connect or subscribe the first time;
+	// it doubles with every failed attempt up to RetryMaxWaitSeconds, which also caps the wait
+	// between the mqtt library's own re-connection attempts
+	RetryInitialWaitMillis int `default:"1000"`
+	RetryMaxWaitSeconds    int `default:"60"`
+	// RetryJitterPercent is the largest part of each wait, in percent, which is randomly
+	// cut from it so that services don't retry in lockstep
+	RetryJitterPercent int `default:"50"`
+	// SensorHeartbeatPeriodSeconds is the period the sensors send heartbeats at; a sensor
+	// which misses SensorMissedHeartbeats of them is disabled until they resume.
+	// If SensorMissedHeartbeats is 0, sensor heartbeats aren't tracked.
+	SensorHeartbeatPeriodSeconds int `default:"30"`
+	SensorMissedHeartbeats       int `default:"0"`
+	// ControllerHeartbeatPeriodSeconds is the period the RSP Controllers send heartbeats at;
+	// while a controller misses ControllerMissedHeartbeats of them, it's disabled and its
+	// commands fail right away. If ControllerMissedHeartbeats is 0, they aren't tracked.
+	ControllerHeartbeatPeriodSeconds int `default:"30"`
+	ControllerMissedHeartbeats       int `default:"0"`
+	// StatusAddress is the address the driver status is served on, e.g. "127.0.0.1:49990";
+	// if empty, it isn't served
+	StatusAddress string `default:""`
 	// TlsInsecureSkipVerify when set to "true", this will disable certificate checking of TLS connections to the MQTT broker
 	TlsInsecureSkipVerify bool
+	// TlsCaFile is a PEM bundle of the CAs trusted to sign the MQTT broker's certificate;
+	// if empty, the system's CAs are trusted
+	TlsCaFile string `default:""`
+	// TlsCertFile and TlsKeyFile are the PEM client certificate and key presented to
+	// the MQTT broker; both or neither must be set
+	TlsCertFile string `default:""`
+	TlsKeyFile  string `default:""`
+	// TlsServerName overrides the host name the MQTT broker's certificate is checked against
+	TlsServerName string `default:""`
+	// TlsMinVersion is the minimum TLS version: 1.0, 1.1, 1.2 or 1.3; if empty, Go's default is used
+	TlsMinVersion string `default:""`
+	// SensorDeviceReadings when set to "true", readings of data produced by a registered
+	// sensor are sent under the sensor's device instead of the ControllerName
+	SensorDeviceReadings bool `default:"false"`
+	// InventoryTagReadings when set to "true", inventory_data is sent as typed readings
+	// of each tag read instead of a single reading of the whole notification
+	InventoryTagReadings bool `default:"false"`
+	// IncomingWorkers is the number of incoming messages processed in parallel; messages
+	// of the same sensor (the last segment of an IncomingTopic ending in /+) or of the same
+	// controller are always processed in order. If 0, there's one per CPU.
+	IncomingWorkers int `default:"0"`
+
+	// ReadingBufferDir is the directory readings are stored in while EdgeX core-data
+	// is unreachable or EdgeX isn't keeping up, to be sent in order once it is.
+	// If empty, readings aren't buffered.
+	ReadingBufferDir string `default:""`
+	// CoreDataURL is the base URL of EdgeX core-data, which is pinged to find out
+	// whether to buffer readings
+	CoreDataURL string `default:"http://edgex-core-data:48080"`
+	// ReadingBufferMaxBytes limits the size of the buffered readings; the oldest are dropped first.
+	// If 0, the size is unlimited.
+	ReadingBufferMaxBytes int `default:"104857600"`
+	// ReadingBufferMaxAgeSeconds is how long buffered readings are kept before they're dropped.
+	// If 0, they're kept until they're sent.
+	ReadingBufferMaxAgeSeconds int `default:"86400"`
 
 	// IncomingTopics is a list of all topics containing data to be ingested
 	IncomingTopics []string
+	// SharedSubscriptionGroup makes the IncomingTopics subscriptions shared by all the
+	// instances of the device service in the group, so each message is ingested once.
+	// If empty, every instance receives every message.
+	SharedSubscriptionGroup string `default:""`
 
 	// CommandTopic is the topic to send commands on
 	CommandTopic string
@@ -58,6 +129,15 @@ type configuration struct {
 	// is the jsonrpc method on the incoming data or the command request.
 	SchemasDir string
 
+	// MqttBrokers lists the URIs of the MQTT brokers to fail over between, in order
+	// of preference, e.g. "ssl://primary:8883,ssl://standby:8883". If empty, the
+	// single broker of the MqttScheme, MqttHost and MqttPort is used.
+	MqttBrokers []string `default:""`
+	// MqttFailbackSeconds is how often to check if the first of the MqttBrokers is
+	// reachable again while another one is in use, and if so, to re-connect to it.
+	// If 0, failover is sticky: the first broker is only tried again on disconnect.
+	MqttFailbackSeconds int `default:"300"`
+
 	// Mqtt connection info
 	MqttScheme   string
 	MqttHost     string
@@ -67,6 +147,26 @@ type configuration struct {
 	// MqttKeepAlive is the keep alive in seconds
 	MqttKeepAlive int
 	MqttClientId  string
+	// MqttCleanSession discards the session at the broker when the client disconnects.
+	// If false, the broker queues QoS 1 and 2 messages while the service is down;
+	// that requires an MqttClientId which is the same on every start.
+	MqttCleanSession bool `default:"true"`
+	// MqttStoreDir is the directory in which the MQTT 3.1.1 client keeps messages
+	// which are in flight, so they survive a restart. If empty, they're kept in memory.
+	MqttStoreDir string `default:""`
+	// MqttProtocolVersion is the MQTT version spoken to the broker, "3.1.1" or "5". With
+	// MQTT 5, commands carry a response topic and correlation data; responses without
+	// correlation data are still matched by their jsonrpc id.
+	MqttProtocolVersion string `default:"3.1.1"`
+
+	// MqttWebsocketPath is the path of ws and wss brokers whose URI doesn't have one
+	MqttWebsocketPath string `default:"/mqtt"`
+	// MqttWebsocketHeaders are "Name: value" HTTP headers sent when opening a
+	// WebSocket, e.g. for an authenticating proxy in front of the broker
+	MqttWebsocketHeaders []string `default:""`
+	// MqttProxy is the http:// URL of the proxy ws and wss brokers are reached through.
+	// If empty, the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables are used.
+	MqttProxy string `default:""`
 
 	// CommandQos is the MQTT Quality of Service 0, 1, or 2 for sending commands
 	CommandQos byte
@@ -76,20 +176,45 @@ type configuration struct {
 	IncomingQos byte
 
 	// Tag decoding
-	TagFormats          []string
-	TagBitBoundary      []int
+	TagFormats     []string
+	TagBitBoundary []int
+	// TagBitTagHeaders are the hex encoded first bytes of the tags the bittag decoder is
+	// meant for; if empty, it's tried for tags no other decoder handles
+	TagBitTagHeaders    []string `default:""`
 	TagURIAuthorityName string
 	TagURIAuthorityDate string
 	SGTINStrictDecoding bool
+	// EPCStrictDecoding requires the values of SSCC, SGLN, GRAI and GIAI tags to be
+	// within the ranges of the EPC Tag Data Standard, like SGTINStrictDecoding
+	EPCStrictDecoding bool `default:"true"`
+	// TagRepresentations are the representations of GS1 EPCs added to each tag read
+	// besides its uri: tag_uri, element_string and/or digital_link
+	TagRepresentations []string `default:""`
+	// TagDigitalLinkDomain is the domain of the digital_link representation, e.g. https://id.gs1.org
+	TagDigitalLinkDomain string `default:"https://id.gs1.org"`
+	// TagDecodeFailurePolicy is what's done with tag reads whose tag data can't be decoded:
+	// "null" sends them with a null uri, "raw" with an EPC raw URI, and "drop" drops them
+	TagDecodeFailurePolicy string `default:"null"`
+	// customTagLayouts are the layouts of the TagFormats which aren't built in, by name,
+	// loaded from their TagDecoder_<name>_* properties
+	customTagLayouts map[string]customTagLayout
 }
 
 // CreateDriverConfig use to load driver config for incoming listener and response listener
 func CreateDriverConfig(configMap map[string]string) (*configuration, error) {
 	config := new(configuration)
 	err := load(configMap, config)
+	if err == nil && !config.MqttCleanSession && hasVolatileTemplateVars(config.MqttClientId) {
+		// the broker only resumes the session of a client with the same id
+		err = fmt.Errorf("%s %q changes on every start, which defeats a persistent session; "+
+			"use a fixed id or {{ hostname }} when %s is false", MqttClientId, config.MqttClientId, MqttCleanSession)
+	}
 	if err == nil {
 		config.MqttClientId, err = replaceTemplateVars(config.MqttClientId)
 	}
+	if err == nil {
+		config.customTagLayouts, err = loadCustomTagLayouts(configMap, config.TagFormats)
+	}
 	return config, err
 }
 
@@ -99,10 +224,16 @@ func load(configMap map[string]string, config *configuration) error {
 	for i := 0; i < configValue.NumField(); i++ {
 		typeField := configValue.Type().Field(i)
 		valueField := configValue.Field(i)
+		if typeField.PkgPath != "" {
+			// unexported fields aren't properties, but derived from them
+			continue
+		}
 
 		val, ok := configMap[typeField.Name]
 		if !ok {
-			return fmt.Errorf("config is missing property '%s'", typeField.Name)
+			if val, ok = typeField.Tag.Lookup("default"); !ok {
+				return fmt.Errorf("config is missing property '%s'", typeField.Name)
+			}
 		}
 		if !valueField.CanSet() {
 			return fmt.Errorf("cannot set field '%s'", typeField.Name)
@@ -135,7 +266,14 @@ func load(configMap map[string]string, config *configuration) error {
 			var slice reflect.Value
 			switch typeField.Type.Elem().Kind() {
 			case reflect.String:
-				slice = reflect.ValueOf(splitVals)
+				// an empty value is an empty list rather than a list of one empty string
+				var strVals []string
+				for _, s := range splitVals {
+					if s = strings.TrimSpace(s); s != "" {
+						strVals = append(strVals, s)
+					}
+				}
+				slice = reflect.ValueOf(strVals)
 			case reflect.Int:
 				slice = reflect.MakeSlice(typeField.Type, len(splitVals), len(splitVals))
 				for idx, toConvert := range splitVals {
@@ -164,6 +302,17 @@ func generateRandomString(length int) string {
 	return string(randomStr)
 }
 
+// hasVolatileTemplateVars returns true if val has template variables
+// which are replaced by a different value on every start
+func hasVolatileTemplateVars(val string) bool {
+	for _, groups := range templateRegex.FindAllStringSubmatch(val, -1) {
+		if groups[1] != "hostname" {
+			return true
+		}
+	}
+	return false
+}
+
 func replaceTemplateVars(val string) (string, error) {
 	var err error
 	var replacement string
@@ -192,6 +341,10 @@ func replaceTemplateVars(val string) (string, error) {
 			replacement = strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10)
 		case "nanos":
 			replacement = strconv.FormatInt(time.Now().UnixNano(), 10)
+		case "hostname":
+			if replacement, err = os.Hostname(); err != nil {
+				return "", err
+			}
 		case "random":
 			// random does not have an inherent size like a uuid or similar,
 			// so give it one here to allow it to be called without a parameter
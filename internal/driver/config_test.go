@@ -14,54 +14,121 @@ package driver
 
 import (
 	"fmt"
+	expect "github.com/intel/rsp-sw-toolkit-im-suite-expect"
+	"os"
 	"regexp"
 	"strconv"
 	"strings"
 	"testing"
 )
 
-func TestCreateDriverConfig(t *testing.T) {
-	configs := map[string]string{
-		ControllerName:             "rsp-controller",
-		MaxWaitTimeForReq:          "10",
-		MaxReconnectWaitSeconds:    "600",
-		TlsInsecureSkipVerify:      "true",
-		CommandTopic:               "rfid/controller/command",
-		ResponseTopic:              "rfid/controller/response",
-		IncomingTopics:             "rfid/controller/alerts,rfid/controller/heartbeat,rfid/controller/notification,rfid/rsp/data/+,rfid/rsp/rsp_status/+",
-		SchemasDir:                 "schemas",
-		RspControllerNotifications: "scheduler_run_state,sensor_config_notification,sensor_connection_state_notification",
-		MqttScheme:                 "tcp",
-		MqttHost:                   "mosquitto-server",
-		MqttPort:                   "1883",
-		MqttUser:                   "",
-		MqttPassword:               "",
-		MqttKeepAlive:              "120",
-		IncomingQos:                "1",
-		ResponseQos:                "1",
-		CommandQos:                 "1",
-		MqttClientId:               "MqttDeviceService",
-		TagFormats:                 "sgtin,bittag",
-		TagBitBoundary:             "8,44,44",
-		TagURIAuthorityName:        "example.com",
-		TagURIAuthorityDate:        "2019-01-31",
-		SGTINStrictDecoding:        "true",
+// validConfigMap returns a driver config map with every field set
+func validConfigMap() map[string]string {
+	return map[string]string{
+		ControllerName:                   "rsp-controller",
+		ControllerIds:                    "",
+		MaxWaitTimeForReq:                "10",
+		MaxReconnectWaitSeconds:          "600",
+		ShutdownWaitSeconds:              "10",
+		RetryInitialWaitMillis:           "500",
+		RetryMaxWaitSeconds:              "30",
+		RetryJitterPercent:               "25",
+		SensorHeartbeatPeriodSeconds:     "30",
+		SensorMissedHeartbeats:           "3",
+		ControllerHeartbeatPeriodSeconds: "60",
+		ControllerMissedHeartbeats:       "2",
+		StatusAddress:                    "127.0.0.1:49990",
+		TlsInsecureSkipVerify:            "true",
+		TlsCaFile:                        "/run/secrets/ca.pem",
+		TlsCertFile:                      "/run/secrets/client.pem",
+		TlsKeyFile:                       "/run/secrets/client.key",
+		TlsServerName:                    "broker.example.com",
+		TlsMinVersion:                    "1.2",
+		SensorDeviceReadings:             "true",
+		InventoryTagReadings:             "false",
+		IncomingWorkers:                  "4",
+		ReadingBufferDir:                 "/var/lib/rsp-mqtt-device-service/buffer",
+		CoreDataURL:                      "http://edgex-core-data:48080",
+		ReadingBufferMaxBytes:            "104857600",
+		ReadingBufferMaxAgeSeconds:       "86400",
+		CommandTopic:                     "rfid/controller/command",
+		ResponseTopic:                    "rfid/controller/response",
+		IncomingTopics:                   "rfid/controller/alerts,rfid/controller/heartbeat,rfid/controller/notification,rfid/rsp/data/+,rfid/rsp/rsp_status/+",
+		SharedSubscriptionGroup:          "rsp-mqtt-device-service",
+		SchemasDir:                       "schemas",
+		RspControllerNotifications:       "scheduler_run_state,sensor_config_notification,sensor_connection_state_notification",
+		MqttBrokers:                      "",
+		MqttFailbackSeconds:              "300",
+		MqttScheme:                       "tcp",
+		MqttHost:                         "mosquitto-server",
+		MqttPort:                         "1883",
+		MqttUser:                         "",
+		MqttPassword:                     "",
+		MqttKeepAlive:                    "120",
+		IncomingQos:                      "1",
+		ResponseQos:                      "1",
+		CommandQos:                       "1",
+		MqttClientId:                     "MqttDeviceService",
+		MqttCleanSession:                 "false",
+		MqttStoreDir:                     "/var/lib/mqtt-device-service",
+		MqttProtocolVersion:              "5",
+		MqttWebsocketPath:                "/mqtt",
+		MqttWebsocketHeaders:             "Authorization: Bearer abc,X-Site: store1",
+		MqttProxy:                        "http://proxy:3128",
+		TagFormats:                       "sgtin,bittag",
+		TagBitTagHeaders:                 "0F,0E",
+		TagBitBoundary:                   "8,44,44",
+		TagURIAuthorityName:              "example.com",
+		TagURIAuthorityDate:              "2019-01-31",
+		SGTINStrictDecoding:              "true",
+		EPCStrictDecoding:                "false",
+		TagRepresentations:               "tag_uri,digital_link",
+		TagDigitalLinkDomain:             "https://id.gs1.org",
+		TagDecodeFailurePolicy:           "raw",
 	}
+}
 
+func TestCreateDriverConfig(t *testing.T) {
+	configs := validConfigMap()
 	cfg, err := CreateDriverConfig(configs)
 	if err != nil {
 		t.Fatalf("Fail to load config, %v", err)
 	}
 
 	if cfg.ControllerName != configs[ControllerName] ||
+		len(cfg.ControllerIds) != 0 ||
 		cfg.MaxWaitTimeForReq != convertInt(configs[MaxWaitTimeForReq]) ||
 		cfg.MaxReconnectWaitSeconds != convertInt(configs[MaxReconnectWaitSeconds]) ||
+		cfg.ShutdownWaitSeconds != convertInt(configs[ShutdownWaitSeconds]) ||
+		cfg.RetryInitialWaitMillis != convertInt(configs[RetryInitialWaitMillis]) ||
+		cfg.RetryMaxWaitSeconds != convertInt(configs[RetryMaxWaitSeconds]) ||
+		cfg.RetryJitterPercent != convertInt(configs[RetryJitterPercent]) ||
+		cfg.SensorHeartbeatPeriodSeconds != convertInt(configs[SensorHeartbeatPeriodSeconds]) ||
+		cfg.SensorMissedHeartbeats != convertInt(configs[SensorMissedHeartbeats]) ||
+		cfg.ControllerHeartbeatPeriodSeconds != convertInt(configs[ControllerHeartbeatPeriodSeconds]) ||
+		cfg.ControllerMissedHeartbeats != convertInt(configs[ControllerMissedHeartbeats]) ||
+		cfg.StatusAddress != configs[StatusAddress] ||
 		cfg.TlsInsecureSkipVerify != convertBool(configs[TlsInsecureSkipVerify]) ||
+		cfg.TlsCaFile != configs[TlsCaFile] ||
+		cfg.TlsCertFile != configs[TlsCertFile] ||
+		cfg.TlsKeyFile != configs[TlsKeyFile] ||
+		cfg.TlsServerName != configs[TlsServerName] ||
+		cfg.TlsMinVersion != configs[TlsMinVersion] ||
+		cfg.SensorDeviceReadings != convertBool(configs[SensorDeviceReadings]) ||
+		cfg.InventoryTagReadings != convertBool(configs[InventoryTagReadings]) ||
+		cfg.IncomingWorkers != convertInt(configs[IncomingWorkers]) ||
+		cfg.ReadingBufferDir != configs[ReadingBufferDir] ||
+		cfg.CoreDataURL != configs[CoreDataURL] ||
+		cfg.ReadingBufferMaxBytes != convertInt(configs[ReadingBufferMaxBytes]) ||
+		cfg.ReadingBufferMaxAgeSeconds != convertInt(configs[ReadingBufferMaxAgeSeconds]) ||
 		convertSlice(cfg.IncomingTopics) != configs[IncomingTopics] ||
+		cfg.SharedSubscriptionGroup != configs[SharedSubscriptionGroup] ||
 		cfg.CommandTopic != configs[CommandTopic] ||
 		cfg.ResponseTopic != configs[ResponseTopic] ||
 		convertSlice(cfg.RspControllerNotifications) != configs[RspControllerNotifications] ||
 		cfg.SchemasDir != configs[SchemasDir] ||
+		len(cfg.MqttBrokers) != 0 ||
+		cfg.MqttFailbackSeconds != convertInt(configs[MqttFailbackSeconds]) ||
 		cfg.MqttScheme != configs[MqttScheme] ||
 		cfg.MqttHost != configs[MqttHost] ||
 		cfg.MqttPort != configs[MqttPort] ||
@@ -69,6 +136,17 @@ func TestCreateDriverConfig(t *testing.T) {
 		cfg.MqttPassword != configs[MqttPassword] ||
 		cfg.MqttKeepAlive != convertInt(configs[MqttKeepAlive]) ||
 		cfg.MqttClientId != configs[MqttClientId] ||
+		cfg.MqttCleanSession != convertBool(configs[MqttCleanSession]) ||
+		cfg.MqttStoreDir != configs[MqttStoreDir] ||
+		cfg.MqttProtocolVersion != configs[MqttProtocolVersion] ||
+		cfg.MqttWebsocketPath != configs[MqttWebsocketPath] ||
+		convertSlice(cfg.MqttWebsocketHeaders) != configs[MqttWebsocketHeaders] ||
+		cfg.MqttProxy != configs[MqttProxy] ||
+		convertSlice(cfg.TagBitTagHeaders) != configs[TagBitTagHeaders] ||
+		cfg.EPCStrictDecoding != convertBool(configs[EPCStrictDecoding]) ||
+		convertSlice(cfg.TagRepresentations) != configs[TagRepresentations] ||
+		cfg.TagDigitalLinkDomain != configs[TagDigitalLinkDomain] ||
+		cfg.TagDecodeFailurePolicy != configs[TagDecodeFailurePolicy] ||
 		cfg.CommandQos != convertByte(configs[CommandQos]) ||
 		cfg.ResponseQos != convertByte(configs[ResponseQos]) ||
 		cfg.IncomingQos != convertByte(configs[IncomingQos]) {
@@ -113,7 +191,82 @@ func TestCreateDriverConfig_fail(t *testing.T) {
 	}
 }
 
+// baselineConfigMap returns a config map with only the properties of the
+// service's first release, as in configurations from before the others existed
+func baselineConfigMap() map[string]string {
+	configs := validConfigMap()
+	baseline := make(map[string]string)
+	for _, key := range []string{
+		ControllerName, MaxWaitTimeForReq, MaxReconnectWaitSeconds, TlsInsecureSkipVerify,
+		IncomingTopics, CommandTopic, ResponseTopic, RspControllerNotifications, SchemasDir,
+		MqttScheme, MqttHost, MqttPort, MqttUser, MqttPassword, MqttKeepAlive, MqttClientId,
+		CommandQos, ResponseQos, IncomingQos, TagFormats, TagBitBoundary,
+		TagURIAuthorityName, TagURIAuthorityDate, SGTINStrictDecoding,
+	} {
+		baseline[key] = configs[key]
+	}
+	return baseline
+}
+
+func TestLoad_baselineConfig(t *testing.T) {
+	w := expect.WrapT(t)
+	configs := baselineConfigMap()
+	config := new(configuration)
+	w.StopOnMismatch().ShouldSucceed(load(configs, config))
+
+	w.ShouldBeEqual(config.ControllerName, configs[ControllerName])
+	w.ShouldBeEqual(config.MqttHost, configs[MqttHost])
+	w.ShouldBeEmpty(config.ControllerIds)
+	w.ShouldBeEmpty(config.MqttBrokers)
+	w.ShouldBeEqual(config.ShutdownWaitSeconds, 10)
+	w.ShouldBeEqual(config.RetryInitialWaitMillis, 1000)
+	w.ShouldBeEqual(config.RetryMaxWaitSeconds, 60)
+	w.ShouldBeEqual(config.StatusAddress, "")
+	w.ShouldBeEqual(config.ReadingBufferDir, "")
+	w.ShouldBeEqual(config.CoreDataURL, "http://edgex-core-data:48080")
+	w.ShouldBeEqual(config.ReadingBufferMaxBytes, 104857600)
+	w.ShouldBeTrue(config.MqttCleanSession)
+	w.ShouldBeEqual(config.MqttProtocolVersion, "3.1.1")
+	w.ShouldBeEqual(config.MqttWebsocketPath, "/mqtt")
+	w.ShouldBeTrue(config.EPCStrictDecoding)
+	w.ShouldBeEqual(config.TagDecodeFailurePolicy, "null")
+	w.As("create").ShouldHaveResult(CreateDriverConfig(configs))
+
+	for key := range configs {
+		missing := baselineConfigMap()
+		delete(missing, key)
+		w.As(key).ShouldFail(load(missing, new(configuration)))
+	}
+}
+
+func TestCreateDriverConfig_volatileClientId(t *testing.T) {
+	w := expect.WrapT(t)
+	configs := validConfigMap()
+	configs[MqttClientId] = "rsp-{{ random(10) }}"
+	w.As("random id, persistent session").ShouldHaveError(CreateDriverConfig(configs))
+
+	configs[MqttCleanSession] = "true"
+	w.As("random id, clean session").ShouldHaveResult(CreateDriverConfig(configs))
+
+	configs[MqttClientId] = "rsp-{{ hostname }}"
+	configs[MqttCleanSession] = "false"
+	w.As("hostname id, persistent session").ShouldHaveResult(CreateDriverConfig(configs))
+}
+
+func TestHasVolatileTemplateVars(t *testing.T) {
+	w := expect.WrapT(t)
+	w.ShouldBeFalse(hasVolatileTemplateVars("fixedString"))
+	w.ShouldBeFalse(hasVolatileTemplateVars("rsp-{{ hostname }}"))
+	w.ShouldBeTrue(hasVolatileTemplateVars("{{ hostname }}-{{ epoch }}"))
+	w.ShouldBeTrue(hasVolatileTemplateVars("rsp-{{uuid}}"))
+}
+
 func TestReplaceTemplateVars(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+
 	tests := []struct {
 		input       string
 		outputRegex string
@@ -148,6 +301,10 @@ func TestReplaceTemplateVars(t *testing.T) {
 			input:       "bar_{{ uuid }}",
 			outputRegex: "bar_[-a-fA-F0-9]{36}",
 		},
+		{
+			input:       "rsp-{{ hostname }}",
+			outputRegex: "^rsp-" + regexp.QuoteMeta(hostname) + "$",
+		},
 		{
 			input:    "rsp{{random}",
 			hasError: true,
@@ -0,0 +1,112 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package driver
+
+import (
+	"github.com/eclipse/paho.mqtt.golang"
+	"github.com/pkg/errors"
+	"strings"
+)
+
+const (
+	// controllerIdTemplate is replaced by the id of each RSP Controller in the
+	// ControllerName and topics of the configuration
+	controllerIdTemplate = "{controller}"
+)
+
+// rspController holds the EdgeX device name and MQTT topics of one RSP Controller
+type rspController struct {
+	// Id is the value of the controllerIdTemplate for this controller;
+	// it is empty if the service is configured for a single controller
+	Id             string
+	DeviceName     string
+	CommandTopic   string
+	ResponseTopic  string
+	IncomingTopics []string
+}
+
+// controllerMessage is an mqtt message received on one of the topics of a controller
+type controllerMessage struct {
+	mqtt.Message
+	controller *rspController
+}
+
+// controllers builds an rspController for each of the ControllerIds by replacing
+// the controllerIdTemplate in the ControllerName and topics. If there are no
+// ControllerIds, the configured values describe a single controller.
+func (config *configuration) controllers() ([]*rspController, error) {
+	templated := append([]string{config.ControllerName, config.CommandTopic, config.ResponseTopic},
+		config.IncomingTopics...)
+
+	if len(config.ControllerIds) == 0 {
+		for _, value := range templated {
+			if strings.Contains(value, controllerIdTemplate) {
+				return nil, errors.Errorf("%q is templated with %s, but no %s are configured",
+					value, controllerIdTemplate, ControllerIds)
+			}
+		}
+		return []*rspController{{
+			DeviceName:     config.ControllerName,
+			CommandTopic:   config.CommandTopic,
+			ResponseTopic:  config.ResponseTopic,
+			IncomingTopics: config.IncomingTopics,
+		}}, nil
+	}
+
+	// every controller needs its own device and topics
+	if len(config.ControllerIds) > 1 {
+		for _, value := range templated {
+			if !strings.Contains(value, controllerIdTemplate) {
+				return nil, errors.Errorf("%q must be templated with %s when multiple %s are configured",
+					value, controllerIdTemplate, ControllerIds)
+			}
+		}
+	}
+
+	controllers := make([]*rspController, 0, len(config.ControllerIds))
+	seen := make(map[string]bool, len(config.ControllerIds))
+	for _, id := range config.ControllerIds {
+		if seen[id] {
+			return nil, errors.Errorf("duplicate controller id %q", id)
+		}
+		seen[id] = true
+
+		controller := &rspController{
+			Id:            id,
+			DeviceName:    strings.Replace(config.ControllerName, controllerIdTemplate, id, -1),
+			CommandTopic:  strings.Replace(config.CommandTopic, controllerIdTemplate, id, -1),
+			ResponseTopic: strings.Replace(config.ResponseTopic, controllerIdTemplate, id, -1),
+		}
+		for _, topic := range config.IncomingTopics {
+			controller.IncomingTopics = append(controller.IncomingTopics,
+				strings.Replace(topic, controllerIdTemplate, id, -1))
+		}
+		controllers = append(controllers, controller)
+	}
+	return controllers, nil
+}
+
+// controllerById returns the controller with the given id, or nil if there isn't one
+func (driver *Driver) controllerById(id string) *rspController {
+	for _, controller := range driver.controllers {
+		if controller.Id == id {
+			return controller
+		}
+	}
+	return nil
+}
+
+// controllerByDeviceName returns the controller registered in EdgeX under the
+// device name, or nil if there isn't one
+func (driver *Driver) controllerByDeviceName(deviceName string) *rspController {
+	for _, controller := range driver.controllers {
+		if controller.DeviceName == deviceName {
+			return controller
+		}
+	}
+	return nil
+}
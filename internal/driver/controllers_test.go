@@ -0,0 +1,75 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package driver
+
+import (
+	expect "github.com/intel/rsp-sw-toolkit-im-suite-expect"
+	"testing"
+)
+
+func TestControllers_single(t *testing.T) {
+	w := expect.WrapT(t)
+	config := &configuration{
+		ControllerName: "rsp-controller",
+		CommandTopic:   "rfid/controller/command",
+		ResponseTopic:  "rfid/controller/response",
+		IncomingTopics: []string{"rfid/controller/alerts", "rfid/rsp/data/+"},
+	}
+
+	controllers := w.ShouldHaveResult(config.controllers()).([]*rspController)
+	w.StopOnMismatch().ShouldHaveLength(controllers, 1)
+	w.ShouldBeEqual(*controllers[0], rspController{
+		DeviceName:     "rsp-controller",
+		CommandTopic:   "rfid/controller/command",
+		ResponseTopic:  "rfid/controller/response",
+		IncomingTopics: []string{"rfid/controller/alerts", "rfid/rsp/data/+"},
+	})
+
+	config.ControllerIds = []string{"store1"}
+	controllers = w.ShouldHaveResult(config.controllers()).([]*rspController)
+	w.As("one id, no templates").StopOnMismatch().ShouldHaveLength(controllers, 1)
+	w.ShouldBeEqual(controllers[0].Id, "store1")
+	w.ShouldBeEqual(controllers[0].DeviceName, "rsp-controller")
+
+	config.ControllerIds = nil
+	config.CommandTopic = "rfid/{controller}/command"
+	w.As("template without ids").ShouldHaveError(config.controllers())
+}
+
+func TestControllers_multiple(t *testing.T) {
+	w := expect.WrapT(t)
+	config := &configuration{
+		ControllerName: "rsp-controller-{controller}",
+		ControllerIds:  []string{"a", "b"},
+		CommandTopic:   "rfid/{controller}/command",
+		ResponseTopic:  "rfid/{controller}/response",
+		IncomingTopics: []string{"rfid/{controller}/alerts", "rfid/{controller}/rsp/data/+"},
+	}
+
+	controllers := w.ShouldHaveResult(config.controllers()).([]*rspController)
+	w.StopOnMismatch().ShouldHaveLength(controllers, 2)
+	w.ShouldBeEqual(*controllers[1], rspController{
+		Id:             "b",
+		DeviceName:     "rsp-controller-b",
+		CommandTopic:   "rfid/b/command",
+		ResponseTopic:  "rfid/b/response",
+		IncomingTopics: []string{"rfid/b/alerts", "rfid/b/rsp/data/+"},
+	})
+
+	d := &Driver{controllers: controllers}
+	w.ShouldBeEqual(d.controllerById("a"), controllers[0])
+	w.ShouldBeNil(d.controllerById("c"))
+	w.ShouldBeEqual(d.controllerByDeviceName("rsp-controller-b"), controllers[1])
+	w.ShouldBeNil(d.controllerByDeviceName("rsp-controller"))
+
+	config.ControllerIds = []string{"a", "a"}
+	w.As("duplicate ids").ShouldHaveError(config.controllers())
+
+	config.ControllerIds = []string{"a", "b"}
+	config.IncomingTopics = append(config.IncomingTopics, "rfid/rsp/rsp_status/+")
+	w.As("shared topic").ShouldHaveError(config.controllers())
+}
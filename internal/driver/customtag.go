@@ -0,0 +1,182 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package driver
+
+import (
+	"github.com/intel/rsp-sw-toolkit-im-suite-tagcode/bitextract"
+	"github.com/pkg/errors"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// customTagKeyPrefix starts the config properties of custom tag decoders, which are
+// TagDecoder_<name>_Prefix, TagDecoder_<name>_Widths, TagDecoder_<name>_Fields and
+// TagDecoder_<name>_URI for each <name> in the TagFormats which isn't built in
+const customTagKeyPrefix = "TagDecoder_"
+
+var (
+	customTagNameRegex        = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+	customTagFieldRegex       = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	customTagPlaceholderRegex = regexp.MustCompile(`{([^{}]*)}`)
+)
+
+// customTagLayout is the layout of a tag encoding defined in the config
+type customTagLayout struct {
+	name string
+	// prefix are the first bits of the tags, as a string of 0s and 1s
+	prefix string
+	// widths are the bit lengths of the fields, which start at the first bit of the tags
+	widths []int
+	fields []string
+	// uri is the template of the URIs, in which each {field} is replaced by the decimal value of the field
+	uri string
+}
+
+// isBuiltinTagFormat returns true if the tag format isn't a custom one
+func isBuiltinTagFormat(format string) bool {
+	format = strings.ToLower(format)
+	_, isEPC := epcSchemes[format]
+	return format == "bittag" || format == "sgtin" || isEPC
+}
+
+// loadCustomTagLayouts loads the layouts of the TagFormats which aren't built in from the config map
+func loadCustomTagLayouts(configMap map[string]string, formats []string) (map[string]customTagLayout, error) {
+	layouts := map[string]customTagLayout{}
+	for _, name := range formats {
+		if isBuiltinTagFormat(name) {
+			continue
+		}
+		if !customTagNameRegex.MatchString(name) {
+			return nil, errors.Errorf("invalid tag format name %q; expected letters, digits and dashes", name)
+		}
+
+		values := map[string]string{}
+		for _, property := range []string{"Prefix", "Widths", "Fields", "URI"} {
+			key := customTagKeyPrefix + name + "_" + property
+			value, ok := configMap[key]
+			if !ok {
+				return nil, errors.Errorf("config is missing property '%s' of tag format %s", key, name)
+			}
+			values[property] = value
+		}
+
+		layout, err := parseCustomTagLayout(name, values["Prefix"], values["Widths"], values["Fields"], values["URI"])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid tag format %s", name)
+		}
+		layouts[name] = layout
+	}
+	return layouts, nil
+}
+
+// parseCustomTagLayout parses the config properties of a custom tag decoder
+func parseCustomTagLayout(name, prefix, widths, fields, uri string) (customTagLayout, error) {
+	layout := customTagLayout{name: name, prefix: strings.TrimSpace(prefix), uri: strings.TrimSpace(uri)}
+	if strings.Trim(layout.prefix, "01") != "" {
+		return layout, errors.Errorf("prefix %q must be bits, e.g. 00001111", prefix)
+	}
+
+	bits := 0
+	for _, w := range strings.Split(widths, ",") {
+		width, err := strconv.Atoi(strings.TrimSpace(w))
+		if err != nil || width <= 0 {
+			return layout, errors.Errorf("invalid width %q; widths must be positive numbers of bits", w)
+		}
+		layout.widths = append(layout.widths, width)
+		bits += width
+	}
+	if bits < len(layout.prefix) {
+		return layout, errors.Errorf("the fields have %d bits, less than the %d of the prefix", bits, len(layout.prefix))
+	}
+
+	seen := map[string]bool{}
+	for _, f := range strings.Split(fields, ",") {
+		f = strings.TrimSpace(f)
+		if !customTagFieldRegex.MatchString(f) {
+			return layout, errors.Errorf("invalid field name %q", f)
+		}
+		if seen[f] {
+			return layout, errors.Errorf("field %s is named more than once", f)
+		}
+		seen[f] = true
+		layout.fields = append(layout.fields, f)
+	}
+	if len(layout.fields) != len(layout.widths) {
+		return layout, errors.Errorf("there are %d field names for %d widths", len(layout.fields), len(layout.widths))
+	}
+
+	if layout.uri == "" {
+		return layout, errors.New("the URI template is empty")
+	}
+	for _, groups := range customTagPlaceholderRegex.FindAllStringSubmatch(layout.uri, -1) {
+		if !seen[groups[1]] {
+			return layout, errors.Errorf("the URI template uses the unknown field {%s}", groups[1])
+		}
+	}
+	return layout, nil
+}
+
+// headers returns the first bytes of the tags the layout's prefix matches,
+// or nil if it has no prefix
+func (layout *customTagLayout) headers() []byte {
+	if layout.prefix == "" {
+		return nil
+	}
+	var headers []byte
+	for b := 0; b <= 0xFF; b++ {
+		if layout.hasPrefix([]byte{byte(b)}, 8) {
+			headers = append(headers, byte(b))
+		}
+	}
+	return headers
+}
+
+// hasPrefix returns true if the first bits of the tag data, up to dataBits
+// of them, match the prefix
+func (layout *customTagLayout) hasPrefix(tagData []byte, dataBits int) bool {
+	for i, bit := range layout.prefix {
+		if i >= dataBits {
+			break
+		}
+		if (tagData[i/8]>>(7-uint(i%8)))&1 != byte(bit-'0') {
+			return false
+		}
+	}
+	return true
+}
+
+// addCustomDecoder adds a decoder of the tag layout; it's used for tags starting
+// with its prefix, or for tags no other decoder handles if it has none
+func (dr *DecoderRing) addCustomDecoder(layout customTagLayout) error {
+	exploder, err := bitextract.NewBitExploder(layout.widths)
+	if err != nil {
+		return err
+	}
+
+	decoder := func(tagData []byte) (DecodedTag, error) {
+		if len(tagData)*8 < len(layout.prefix) || !layout.hasPrefix(tagData, len(layout.prefix)) {
+			return DecodedTag{}, errors.Errorf("tag data doesn't start with the prefix %s", layout.prefix)
+		}
+		fields, err := exploder.Explode(tagData)
+		if err != nil {
+			return DecodedTag{}, err
+		}
+		values := make(map[string]string, len(fields))
+		for i, field := range fields {
+			values[layout.fields[i]] = new(big.Int).SetBytes(field).String()
+		}
+		URI := customTagPlaceholderRegex.ReplaceAllStringFunc(layout.uri, func(placeholder string) string {
+			return values[placeholder[1:len(placeholder)-1]]
+		})
+		return DecodedTag{URI: URI}, nil
+	}
+
+	dr.Decoders = append(dr.Decoders, NamedDecoder{Name: layout.name, TagDecoder: decoder, Headers: layout.headers()})
+	return nil
+}
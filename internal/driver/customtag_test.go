@@ -0,0 +1,94 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package driver
+
+import (
+	expect "github.com/intel/rsp-sw-toolkit-im-suite-expect"
+	"testing"
+)
+
+func TestParseCustomTagLayout(t *testing.T) {
+	w := expect.WrapT(t)
+
+	layout := w.ShouldHaveResult(parseCustomTagLayout("pallet", "00001111", "8, 24,64",
+		"header,site, serial", "urn:example:pallet:{site}.{serial}")).(customTagLayout)
+	w.ShouldBeEqual(layout.widths, []int{8, 24, 64})
+	w.ShouldBeEqual(layout.fields, []string{"header", "site", "serial"})
+
+	parse := func(prefix, widths, fields, uri string) error {
+		_, err := parseCustomTagLayout("x", prefix, widths, fields, uri)
+		return err
+	}
+	w.ShouldSucceed(parse("", "96", "id", "urn:example:{id}"))
+
+	w.As("prefix").ShouldFail(parse("0F", "8", "a", "urn:x:{a}"))
+	w.As("width").ShouldFail(parse("", "8,0", "a,b", "urn:x:{a}"))
+	w.As("shorter than prefix").ShouldFail(parse("0000111100", "8", "a", "urn:x:{a}"))
+	w.As("field count").ShouldFail(parse("", "8,8", "a", "urn:x:{a}"))
+	w.As("field name").ShouldFail(parse("", "8", "a.b", "urn:x:{a}"))
+	w.As("duplicate field").ShouldFail(parse("", "8,8", "a,a", "urn:x:{a}"))
+	w.As("unknown field").ShouldFail(parse("", "8", "a", "urn:x:{b}"))
+	w.As("no URI").ShouldFail(parse("", "8", "a", " "))
+}
+
+func TestLoadCustomTagLayouts(t *testing.T) {
+	w := expect.WrapT(t)
+
+	configMap := validConfigMap()
+	configMap[TagFormats] = "sgtin,pallet,bittag"
+	configMap["TagDecoder_pallet_Prefix"] = "0000"
+	configMap["TagDecoder_pallet_Widths"] = "8,88"
+	configMap["TagDecoder_pallet_Fields"] = "header,serial"
+	configMap["TagDecoder_pallet_URI"] = "urn:example:pallet:{serial}"
+
+	config := w.ShouldHaveResult(CreateDriverConfig(configMap)).(*configuration)
+	w.ShouldHaveLength(config.customTagLayouts, 1)
+	w.ShouldBeEqual(config.customTagLayouts["pallet"].uri, "urn:example:pallet:{serial}")
+
+	delete(configMap, "TagDecoder_pallet_URI")
+	w.As("missing property").ShouldHaveError(CreateDriverConfig(configMap))
+
+	configMap[TagFormats] = "sgtin,pallet.v2"
+	w.As("invalid name").ShouldHaveError(CreateDriverConfig(configMap))
+}
+
+func TestDecoderRing_Custom_Layouts(t *testing.T) {
+	w := expect.WrapT(t)
+
+	dr := DecoderRing{}
+	dr.AddSGTINDecoder(true)
+	for _, layout := range []customTagLayout{
+		{name: "pallet", prefix: "00001111", widths: []int{8, 24, 64},
+			fields: []string{"header", "site", "serial"}, uri: "urn:example:pallet:{site}.{serial}"},
+		// every tag starting with 0x4_ to 0x7_
+		{name: "case", prefix: "01", widths: []int{4, 12, 80},
+			fields: []string{"kind", "lot", "serial"}, uri: "urn:example:case:{lot}:{kind}-{serial}"},
+		{name: "any", widths: []int{8},
+			fields: []string{"header"}, uri: "urn:example:unknown:{header}"},
+	} {
+		w.ShouldSucceed(dr.addCustomDecoder(layout))
+	}
+	w.ShouldBeEqual(dr.Decoders[1].Headers, []byte{0x0F})
+	w.ShouldHaveLength(dr.Decoders[2].Headers, 64)
+	w.ShouldBeNil(dr.Decoders[3].Headers)
+
+	for tagData, expected := range map[string][2]string{
+		"0F00000C00000000000014D2": {"urn:example:pallet:12.5330", "pallet"},
+		"4A0100000000000000000007": {"urn:example:case:2561:4-7", "case"},
+		"7A0100000000000000000007": {"urn:example:case:2561:7-7", "case"},
+		"30143639F84191AD22901607": {"urn:epc:id:sgtin:0888446.067142.193853396487", "SGTIN"},
+		"8F00":                     {"urn:example:unknown:143", "any"},
+	} {
+		tag, decoder, err := dr.Decode(tagData)
+		w.As(tagData).StopOnMismatch().ShouldSucceed(err)
+		w.As(tagData).ShouldBeEqual(tag.URI, expected[0])
+		w.As(tagData).ShouldBeEqual(decoder, expected[1])
+	}
+
+	// too short for the pallet, so it falls through to the one for any tag
+	w.ShouldBeEqual(w.ShouldHaveResult(dr.TagDataToURI("0F00")), "urn:example:unknown:15")
+}
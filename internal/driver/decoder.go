@@ -12,71 +12,311 @@ import (
 	"github.com/intel/rsp-sw-toolkit-im-suite-tagcode/bittag"
 	"github.com/intel/rsp-sw-toolkit-im-suite-tagcode/epc"
 	"github.com/pkg/errors"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync"
 )
 
-type TagDecoder func(tagData []byte) (URI string, err error)
+type TagDecoder func(tagData []byte) (DecodedTag, error)
+
+const (
+	// representations of decoded tags other than their URI
+	tagURIRepresentation        = "tag_uri"
+	elementStringRepresentation = "element_string"
+	digitalLinkRepresentation   = "digital_link"
+)
+
+// DecodedTag is tag data converted to a URI and, for GS1 EPCs, its other representations
+type DecodedTag struct {
+	// URI is the pure identity URI of EPCs, or the URI of other tags
+	URI string
+	// TagURI is the EPC tag URI, which unlike the pure identity URI includes the filter value
+	TagURI string
+	// Elements are the GS1 keys of the EPC, e.g. the GTIN and serial of an SGTIN
+	Elements []GS1Element
+}
+
+// GS1Element is a GS1 application identifier and its value
+type GS1Element struct {
+	AI    string
+	Value string
+}
+
+// ElementString returns the GS1 element string of the tag, e.g.
+// "(01)10888446671424(21)193853396487", or "" if it isn't a GS1 EPC
+func (tag DecodedTag) ElementString() string {
+	var b strings.Builder
+	for _, e := range tag.Elements {
+		b.WriteString("(" + e.AI + ")" + e.Value)
+	}
+	return b.String()
+}
+
+// DigitalLink returns the GS1 digital link URI of the tag on the domain, e.g.
+// "https://id.gs1.org/01/10888446671424/21/193853396487", or "" if it isn't a GS1 EPC
+func (tag DecodedTag) DigitalLink(domain string) string {
+	if len(tag.Elements) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString(strings.TrimSuffix(domain, "/"))
+	for _, e := range tag.Elements {
+		b.WriteString("/" + e.AI + "/" + url.PathEscape(e.Value))
+	}
+	return b.String()
+}
+
+// representation returns the named representation of the tag, or "" if the tag doesn't have it
+func (tag DecodedTag) representation(name, digitalLinkDomain string) string {
+	switch name {
+	case tagURIRepresentation:
+		return tag.TagURI
+	case elementStringRepresentation:
+		return tag.ElementString()
+	case digitalLinkRepresentation:
+		return tag.DigitalLink(digitalLinkDomain)
+	}
+	return ""
+}
+
+const (
+	// policies for tag reads whose tag data can't be decoded
+	nullURIPolicy = "null"
+	rawURIPolicy  = "raw"
+	dropPolicy    = "drop"
+)
+
+// rawTagURI returns the EPC raw URI of hex encoded tag data, e.g. urn:epc:raw:96.x3074257BF7194E4000001A85
+func rawTagURI(tagData string) string {
+	return fmt.Sprintf("urn:epc:raw:%d.x%s", len(tagData)*4, strings.ToUpper(tagData))
+}
+
+// validateTagDecoding checks the TagRepresentations and TagDecodeFailurePolicy of the config
+func (config *configuration) validateTagDecoding() error {
+	switch config.TagDecodeFailurePolicy {
+	case nullURIPolicy, rawURIPolicy, dropPolicy:
+	default:
+		return errors.Errorf("unknown %s %q; expected %s, %s or %s", TagDecodeFailurePolicy,
+			config.TagDecodeFailurePolicy, nullURIPolicy, rawURIPolicy, dropPolicy)
+	}
+	for _, name := range config.TagRepresentations {
+		switch name {
+		case tagURIRepresentation, elementStringRepresentation, digitalLinkRepresentation:
+		default:
+			return errors.Errorf("unknown tag representation %q; expected %s, %s or %s", name,
+				tagURIRepresentation, elementStringRepresentation, digitalLinkRepresentation)
+		}
+	}
+	return nil
+}
+
+// gs1CheckDigit returns the GS1 check digit of the decimal digits
+func gs1CheckDigit(digits string) int {
+	sum := 0
+	for i := len(digits) - 1; i >= 0; i-- {
+		weight := 1
+		if (len(digits)-1-i)%2 == 0 {
+			weight = 3
+		}
+		sum += int(digits[i]-'0') * weight
+	}
+	return (10 - sum%10) % 10
+}
+
+// withCheckDigit appends the GS1 check digit to the decimal digits
+func withCheckDigit(digits string) string {
+	return digits + strconv.Itoa(gs1CheckDigit(digits))
+}
 
 type NamedDecoder struct {
 	Name string
 	TagDecoder
+	// Headers are the first bytes of the tag data the decoder handles, i.e. the EPC
+	// headers; a decoder without Headers is tried for tags no other decoder handles
+	Headers []byte
 }
 
+// parseTagHeaders parses the hex encoded headers of tag data, e.g. "0F"
+func parseTagHeaders(hexHeaders []string) ([]byte, error) {
+	headers := make([]byte, len(hexHeaders))
+	for i, h := range hexHeaders {
+		b, err := hex.DecodeString(strings.TrimPrefix(strings.ToLower(h), "0x"))
+		if err != nil || len(b) != 1 {
+			return nil, errors.Errorf("invalid tag header %q; expected a hex byte such as 0F", h)
+		}
+		headers[i] = b[0]
+	}
+	return headers, nil
+}
+
+// handles returns true if the decoder is meant for tag data with the header
+func (nd *NamedDecoder) handles(header byte) bool {
+	for _, h := range nd.Headers {
+		if h == header {
+			return true
+		}
+	}
+	return false
+}
+
+// DecoderRing converts tag data to URIs. Tag data is routed by its header to the
+// decoders with matching Headers; if none of them decode it, or no decoder handles
+// the header, the decoders without Headers are tried, in the order they were added.
 type DecoderRing struct {
 	Decoders []NamedDecoder
+
+	// matches counts the tags decoded by each decoder, and failures the tags
+	// each decoder tried but no decoder decoded, by decoder name
+	matches  counters
+	failures counters
 }
 
-func (dr *DecoderRing) AddBitTagDecoder(authority, date string, widths []int) error {
+// noDecoderName is the name failures of tags which no decoder handles are counted under
+const noDecoderName = "none"
+
+// AddBitTagDecoder adds a decoder for the bit widths which produces tag URIs of the
+// authority and date; if headers are given, it's only used for tags starting with them
+func (dr *DecoderRing) AddBitTagDecoder(authority, date string, widths []int, headers ...byte) error {
 	btd, err := bittag.NewDecoder(authority, date, widths)
 	if err != nil {
 		return err
 	}
 
-	decoder := func(tagData []byte) (string, error) {
+	decoder := func(tagData []byte) (DecodedTag, error) {
 		bitTag, err := btd.Decode(tagData)
 		if err != nil {
-			return "", err
+			return DecodedTag{}, err
 		}
-		return bitTag.URI(), nil
+		return DecodedTag{URI: bitTag.URI()}, nil
 	}
 
-	dr.Decoders = append(dr.Decoders, NamedDecoder{Name: btd.Prefix(), TagDecoder: decoder})
+	dr.Decoders = append(dr.Decoders, NamedDecoder{Name: btd.Prefix(), TagDecoder: decoder, Headers: headers})
 	return nil
 }
 
+// AddSGTINDecoder adds a decoder of SGTIN-96 and SGTIN-198 EPCs; if strict, their
+// values must be within the ranges of the EPC Tag Data Standard
 func (dr *DecoderRing) AddSGTINDecoder(strict bool) {
-	decoder := func(tagData []byte) (URI string, err error) {
-		var s epc.SGTIN
-		s, err = epc.DecodeSGTIN(tagData)
+	decoder := func(tagData []byte) (DecodedTag, error) {
+		s, err := epc.DecodeSGTIN(tagData)
 		if strict && err == nil {
 			err = s.ValidateRanges()
 		}
-		if err == nil {
-			URI = s.URI()
+		if err != nil {
+			return DecodedTag{}, err
 		}
-		return
+
+		scheme := "sgtin-96"
+		if tagData[0] == epc.SGTIN198Header {
+			scheme = "sgtin-198"
+		}
+		URI := s.URI()
+		return DecodedTag{
+			URI: URI,
+			TagURI: fmt.Sprintf("urn:epc:tag:%s:%d.%s", scheme, s.Filter(),
+				strings.TrimPrefix(URI, epc.SGTINPureURIPrefix+":")),
+			Elements: []GS1Element{{"01", s.GTIN()}, {"21", s.Serial()}},
+		}, nil
 	}
 
-	dr.Decoders = append(dr.Decoders, NamedDecoder{Name: "SGTIN", TagDecoder: decoder})
+	dr.Decoders = append(dr.Decoders, NamedDecoder{
+		Name:       "SGTIN",
+		TagDecoder: decoder,
+		Headers:    []byte{epc.SGTIN96Header, epc.SGTIN198Header},
+	})
 }
 
+// TagDataToURI converts the hex encoded tag data to a URI
 func (dr *DecoderRing) TagDataToURI(tagData string) (string, error) {
+	tag, _, err := dr.Decode(tagData)
+	return tag.URI, err
+}
+
+// Decode converts the hex encoded tag data, and returns the name of the decoder which did
+func (dr *DecoderRing) Decode(tagData string) (tag DecodedTag, decoderName string, err error) {
 	tagDataBytes, err := hex.DecodeString(tagData)
 	if err != nil {
-		return "", errors.Wrap(err, "failed to decode tag hex data")
+		dr.failures.add(noDecoderName)
+		return DecodedTag{}, "", errors.Wrap(err, "failed to decode tag hex data")
 	}
+	if len(tagDataBytes) == 0 {
+		dr.failures.add(noDecoderName)
+		return DecodedTag{}, "", errors.New("no tag data")
+	}
+	header := tagDataBytes[0]
 
-	var decodingErrors []string
-	for _, decoder := range dr.Decoders {
-		tagUri, err := decoder.TagDecoder(tagDataBytes)
-		if err == nil {
-			return tagUri, nil
+	var tried, decodingErrors []string
+	try := func(decoder *NamedDecoder) bool {
+		var err error
+		if tag, err = decoder.TagDecoder(tagDataBytes); err != nil {
+			tried = append(tried, decoder.Name)
+			decodingErrors = append(decodingErrors, fmt.Sprintf("%s: %v", decoder.Name, err))
+			return false
 		}
-		decodingErrors = append(decodingErrors, fmt.Sprintf("%s: %v",
-			decoder.Name, err))
+		decoderName = decoder.Name
+		dr.matches.add(decoderName)
+		return true
 	}
 
-	return "", errors.Errorf("no decoder successfully decoded the tag "+
+	// first the decoders meant for the header, then the ones for any tag
+	for i := range dr.Decoders {
+		if dr.Decoders[i].handles(header) && try(&dr.Decoders[i]) {
+			return tag, decoderName, nil
+		}
+	}
+	for i := range dr.Decoders {
+		if len(dr.Decoders[i].Headers) == 0 && try(&dr.Decoders[i]) {
+			return tag, decoderName, nil
+		}
+	}
+
+	if len(tried) == 0 {
+		dr.failures.add(noDecoderName)
+		return DecodedTag{}, "", errors.Errorf("no decoder handles tag data with header 0x%02X", header)
+	}
+	for _, name := range tried {
+		dr.failures.add(name)
+	}
+	return DecodedTag{}, "", errors.Errorf("no decoder successfully decoded the tag "+
 		"data: individual decoder errors are as follows:\n%s",
 		strings.Join(decodingErrors, "\n"))
 }
+
+// Matches returns the number of tags decoded by each decoder, by name
+func (dr *DecoderRing) Matches() map[string]uint64 {
+	return dr.matches.snapshot()
+}
+
+// Failures returns the number of tags each decoder failed to decode, by name;
+// tags no decoder handles, or which aren't hex, are counted under "none"
+func (dr *DecoderRing) Failures() map[string]uint64 {
+	return dr.failures.snapshot()
+}
+
+// counters counts occurrences by key
+type counters struct {
+	mutex  sync.Mutex
+	counts map[string]uint64
+}
+
+// add counts an occurrence of the key
+func (c *counters) add(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.counts == nil {
+		c.counts = map[string]uint64{}
+	}
+	c.counts[key]++
+}
+
+// snapshot returns a copy of the counts
+func (c *counters) snapshot() map[string]uint64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	counts := make(map[string]uint64, len(c.counts))
+	for key, n := range c.counts {
+		counts[key] = n
+	}
+	return counts
+}
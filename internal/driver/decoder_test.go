@@ -86,3 +86,112 @@ func TestDecoderRing_SGTINAndCustom(t *testing.T) {
 	URI = w.ShouldHaveResult(dr.TagDataToURI(almostSGTIN)).(string)
 	w.ShouldContainStr(URI, "tag:test.com,2019-01-01")
 }
+
+func TestDecoderRing_Routing(t *testing.T) {
+	w := expect.WrapT(t)
+
+	dr := DecoderRing{}
+	dr.AddSGTINDecoder(true)
+	w.ShouldSucceed(dr.AddBitTagDecoder(
+		"test.com", "2019-01-01", []int{8, 48, 40}, 0x0F))
+
+	tag, decoder, err := dr.Decode("30143639F84191AD22901607")
+	w.ShouldSucceed(err)
+	w.ShouldBeEqual(tag.URI, "urn:epc:id:sgtin:0888446.067142.193853396487")
+	w.ShouldBeEqual(decoder, "SGTIN")
+
+	tag, decoder, err = dr.Decode("0F00000000000C00000014D2")
+	w.ShouldSucceed(err)
+	w.ShouldBeEqual(tag.URI, "tag:test.com,2019-01-01:15.12.5330")
+	w.ShouldBeEqual(decoder, "tag:test.com,2019-01-01")
+
+	// an invalid SGTIN isn't a bittag once bittags have their own header
+	w.As("invalid SGTIN").ShouldHaveError(dr.TagDataToURI("36143639F84191A465D9B37A176C5EB1769D72E557D5005CBC"))
+	w.As("unknown header").ShouldHaveError(dr.TagDataToURI("1100000000000C00000014D2"))
+	w.As("no data").ShouldHaveError(dr.TagDataToURI(""))
+
+	w.ShouldBeEqual(dr.Matches(), map[string]uint64{"SGTIN": 1, "tag:test.com,2019-01-01": 1})
+	w.ShouldBeEqual(dr.Failures(), map[string]uint64{"SGTIN": 1, noDecoderName: 2})
+}
+
+func TestDecoderRing_RoutingSkipsOtherDecoders(t *testing.T) {
+	w := expect.WrapT(t)
+
+	var tried []string
+	decoder := func(name string) NamedDecoder {
+		return NamedDecoder{Name: name, TagDecoder: func(tagData []byte) (DecodedTag, error) {
+			tried = append(tried, name)
+			return DecodedTag{URI: name}, nil
+		}}
+	}
+
+	dr := DecoderRing{}
+	sgtin := decoder("sgtin")
+	sgtin.Headers = []byte{0x30}
+	dr.Decoders = append(dr.Decoders, sgtin, decoder("any"))
+
+	w.ShouldBeEqual(w.ShouldHaveResult(dr.TagDataToURI("0F")), "any")
+	w.ShouldBeEqual(tried, []string{"any"})
+}
+
+func TestParseTagHeaders(t *testing.T) {
+	w := expect.WrapT(t)
+	w.ShouldBeEqual(w.ShouldHaveResult(parseTagHeaders(nil)), []byte{})
+	w.ShouldBeEqual(w.ShouldHaveResult(parseTagHeaders([]string{"0F", "0xe1"})), []byte{0x0F, 0xE1})
+	w.ShouldHaveError(parseTagHeaders([]string{"F"}))
+	w.ShouldHaveError(parseTagHeaders([]string{"0F0E"}))
+}
+
+func TestDecodedTag_Representations(t *testing.T) {
+	w := expect.WrapT(t)
+
+	dr := DecoderRing{}
+	dr.AddSGTINDecoder(true)
+	w.ShouldSucceed(dr.AddBitTagDecoder("test.com", "2019-01-01", []int{8, 48, 40}))
+
+	tag, _, err := dr.Decode("3034257BF40C0E4000001A85")
+	w.StopOnMismatch().ShouldSucceed(err)
+	w.ShouldBeEqual(tag.URI, "urn:epc:id:sgtin:0614141.012345.6789")
+	w.ShouldBeEqual(tag.TagURI, "urn:epc:tag:sgtin-96:1.0614141.012345.6789")
+	w.ShouldBeEqual(tag.ElementString(), "(01)00614141123452(21)6789")
+	w.ShouldBeEqual(tag.DigitalLink("https://id.gs1.org/"), "https://id.gs1.org/01/00614141123452/21/6789")
+	w.ShouldBeEqual(tag.representation(tagURIRepresentation, ""), tag.TagURI)
+
+	tag, _, err = dr.Decode("36143639F8419198B966E1AB366E5B3470DC00000000000000")
+	w.StopOnMismatch().ShouldSucceed(err)
+	w.ShouldBeEqual(tag.TagURI, "urn:epc:tag:sgtin-198:0.0888446.067142.193853396487")
+	w.ShouldBeEqual(tag.ElementString(), "(01)00888446671424(21)193853396487")
+
+	tag, _, err = dr.Decode("0F00000000000C00000014D2")
+	w.StopOnMismatch().ShouldSucceed(err)
+	w.As("not an EPC").ShouldBeEqual(tag.TagURI, "")
+	w.ShouldBeEqual(tag.ElementString(), "")
+	w.ShouldBeEqual(tag.DigitalLink("https://id.gs1.org"), "")
+
+	escaped := DecodedTag{Elements: []GS1Element{{"01", "80614141123458"}, {"21", "a/b?c"}}}
+	w.ShouldBeEqual(escaped.DigitalLink("https://example.com"),
+		"https://example.com/01/80614141123458/21/a%2Fb%3Fc")
+}
+
+func TestValidateTagDecoding(t *testing.T) {
+	w := expect.WrapT(t)
+	w.ShouldSucceed((&configuration{TagDecodeFailurePolicy: nullURIPolicy}).validateTagDecoding())
+	w.ShouldSucceed((&configuration{TagDecodeFailurePolicy: dropPolicy, TagRepresentations: []string{
+		tagURIRepresentation, elementStringRepresentation, digitalLinkRepresentation}}).validateTagDecoding())
+	w.ShouldFail((&configuration{TagDecodeFailurePolicy: rawURIPolicy, TagRepresentations: []string{"gtin"}}).validateTagDecoding())
+	w.ShouldFail((&configuration{TagDecodeFailurePolicy: "skip"}).validateTagDecoding())
+	w.ShouldFail((&configuration{}).validateTagDecoding())
+}
+
+func TestRawTagURI(t *testing.T) {
+	w := expect.WrapT(t)
+	w.ShouldBeEqual(rawTagURI("3074257bf7194e4000001a85"), "urn:epc:raw:96.x3074257BF7194E4000001A85")
+	w.ShouldBeEqual(rawTagURI("ABC"), "urn:epc:raw:12.xABC")
+}
+
+func TestGS1CheckDigit(t *testing.T) {
+	w := expect.WrapT(t)
+	w.ShouldBeEqual(withCheckDigit("8061414112345"), "80614141123458")
+	w.ShouldBeEqual(withCheckDigit("061414112345"), "0614141123452")
+	w.ShouldBeEqual(withCheckDigit("10614141234567890"), "106141412345678908")
+}
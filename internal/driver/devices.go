@@ -0,0 +1,251 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package driver
+
+import (
+	sdk "github.com/edgexfoundry/device-sdk-go"
+	edgexModels "github.com/edgexfoundry/go-mod-core-contracts/models"
+	"github.com/pkg/errors"
+)
+
+const (
+	// mqttProtocol is the key of the protocol properties this service writes
+	// for every device it registers
+	mqttProtocol = "mqtt"
+
+	schemeProperty = "Scheme"
+	// deviceTypeProperty tells commands whether a device is a sensor or a controller
+	deviceTypeProperty = "DeviceType"
+	// deviceIdProperty is the device_id used by the RSP Controller for the device,
+	// which is kept even if the device is renamed in EdgeX
+	deviceIdProperty = "DeviceId"
+	// controllerIdProperty is the id of the RSP Controller commands for the device are sent to
+	controllerIdProperty = "ControllerId"
+
+	controllerDeviceType = "controller"
+	sensorDeviceType     = "sensor"
+)
+
+// deviceTypeForProfile returns the device type of devices using the profile
+func deviceTypeForProfile(profileName string) string {
+	if profileName == rspDeviceProfile {
+		return sensorDeviceType
+	}
+	return controllerDeviceType
+}
+
+// deviceProtocols returns the protocol properties used to route commands to the device
+func (driver *Driver) deviceProtocols(deviceId string, profileName string, controllerId string) map[string]edgexModels.ProtocolProperties {
+	return map[string]edgexModels.ProtocolProperties{
+		mqttProtocol: {
+			schemeProperty:       driver.Config.MqttScheme,
+			deviceTypeProperty:   deviceTypeForProfile(profileName),
+			deviceIdProperty:     deviceId,
+			controllerIdProperty: controllerId,
+		},
+	}
+}
+
+// registerDeviceIfNeeded registers an MQTT device with EdgeX for the purposes of calling commands and receiving data
+func (driver *Driver) registerDeviceIfNeeded(deviceId string, profileName string, controllerId string) {
+	if device, ok := driver.lookupDevice(sdk.RunningService(), deviceId); ok {
+		driver.Logger.Debug("Device already exists, not registering",
+			"deviceId", deviceId, "device", device.Name, "profile", profileName)
+		driver.updateProtocolsIfNeeded(device, deviceId, profileName, controllerId)
+		driver.rememberSensor(deviceId, device.Name, profileName)
+		return
+	}
+
+	driver.Logger.Debug("Device not found in EdgeX database. Now Registering.",
+		"deviceId", deviceId, "profile", profileName, "controllerId", controllerId)
+	_, err := sdk.RunningService().AddDevice(edgexModels.Device{
+		Name:           deviceId,
+		AdminState:     edgexModels.Unlocked,
+		OperatingState: edgexModels.Enabled,
+		Protocols:      driver.deviceProtocols(deviceId, profileName, controllerId),
+		Profile: edgexModels.DeviceProfile{
+			Name: profileName,
+		},
+	})
+	if err != nil {
+		driver.Logger.Error("Device registration failed",
+			"device", deviceId, "profile", profileName, "cause", err)
+		return
+	}
+	driver.rememberSensor(deviceId, deviceId, profileName)
+}
+
+// rememberSensor records the EdgeX device name of a registered sensor
+func (driver *Driver) rememberSensor(deviceId string, deviceName string, profileName string) {
+	if deviceTypeForProfile(profileName) == sensorDeviceType {
+		driver.sensorDevices.Store(deviceId, deviceName)
+	}
+}
+
+// updateProtocolsIfNeeded adds the routing properties to a device registered
+// before they were written at registration time, and updates the controller
+// of a device that is now reported by a different one
+func (driver *Driver) updateProtocolsIfNeeded(device edgexModels.Device, deviceId string, profileName string, controllerId string) {
+	props := device.Protocols[mqttProtocol]
+	if props[deviceTypeProperty] != "" && props[deviceIdProperty] != "" &&
+		props[controllerIdProperty] == controllerId {
+		return
+	}
+
+	protocols := make(map[string]edgexModels.ProtocolProperties, len(device.Protocols)+1)
+	for name, p := range device.Protocols {
+		protocols[name] = p
+	}
+	updated := driver.deviceProtocols(deviceId, profileName, controllerId)[mqttProtocol]
+	for key, value := range props {
+		if _, ok := updated[key]; !ok {
+			updated[key] = value
+		}
+	}
+	protocols[mqttProtocol] = updated
+	device.Protocols = protocols
+
+	driver.Logger.Info("Updating routing properties of existing device",
+		"device", device.Name, "deviceId", deviceId, "profile", profileName, "controllerId", controllerId)
+	if err := sdk.RunningService().UpdateDevice(device); err != nil {
+		driver.Logger.Error("Device update failed",
+			"device", device.Name, "profile", profileName, "cause", err)
+	}
+}
+
+// updateOperatingStates enables the devices of the RSP Controllers while the driver
+// is connected to the broker and disables them while it isn't, so EdgeX rejects their
+// commands rather than waiting for them to time out. Silent sensors stay disabled.
+// Calls are serialized, and each one applies the states at the time it runs, so the
+// last one wins.
+func (driver *Driver) updateOperatingStates() {
+	svc := sdk.RunningService()
+	if svc == nil {
+		return
+	}
+
+	driver.operatingStateMutex.Lock()
+	defer driver.operatingStateMutex.Unlock()
+
+	for _, device := range svc.Devices() {
+		if device.Profile.Name != rspControllerDeviceProfile && device.Profile.Name != rspDeviceProfile {
+			continue
+		}
+		state := driver.operatingState(device)
+		if device.OperatingState == state {
+			continue
+		}
+
+		device.OperatingState = state
+		if err := svc.UpdateDevice(device); err != nil {
+			driver.Logger.Error("Device operating state update failed",
+				"device", device.Name, "operatingState", state, "cause", err)
+			continue
+		}
+		driver.Logger.Info("Device operating state updated", "device", device.Name, "operatingState", state)
+	}
+}
+
+// operatingState returns the OperatingState the device should be in
+func (driver *Driver) operatingState(device edgexModels.Device) edgexModels.OperatingState {
+	if !driver.isConnected() {
+		return edgexModels.Disabled
+	}
+	if deviceId := driver.sensorDeviceId(device.Name, device.Protocols); deviceId != "" {
+		if driver.sensorHeartbeats.isSilent(deviceId) {
+			return edgexModels.Disabled
+		}
+	} else if controller := driver.controllerByDeviceName(device.Name); controller != nil && driver.isControllerSilent(controller) {
+		return edgexModels.Disabled
+	}
+	return edgexModels.Enabled
+}
+
+// deviceCache is the part of the device service used to look up its devices
+type deviceCache interface {
+	GetDeviceByName(name string) (edgexModels.Device, error)
+	Devices() []edgexModels.Device
+}
+
+// lookupDevice returns the device whose device_id is deviceId, like findDevice. It
+// first tries the name of a known sensor and the device_id itself as device names,
+// and only scans all the devices if neither of them has the device_id.
+func (driver *Driver) lookupDevice(devices deviceCache, deviceId string) (edgexModels.Device, bool) {
+	names := []string{deviceId}
+	if name, ok := driver.sensorDevices.Load(deviceId); ok && name.(string) != deviceId {
+		names = []string{name.(string), deviceId}
+	}
+	for _, name := range names {
+		device, err := devices.GetDeviceByName(name)
+		if err != nil {
+			continue
+		}
+		if device.Protocols[mqttProtocol][deviceIdProperty] == deviceId {
+			return device, true
+		}
+	}
+	return findDevice(devices.Devices(), deviceId)
+}
+
+// findDevice returns the device whose device_id is deviceId. Devices registered
+// before the deviceIdProperty existed are matched by their name instead.
+func findDevice(devices []edgexModels.Device, deviceId string) (edgexModels.Device, bool) {
+	for _, device := range devices {
+		if device.Protocols[mqttProtocol][deviceIdProperty] == deviceId {
+			return device, true
+		}
+	}
+	for _, device := range devices {
+		if device.Name == deviceId && device.Protocols[mqttProtocol][deviceIdProperty] == "" {
+			return device, true
+		}
+	}
+	return edgexModels.Device{}, false
+}
+
+// sensorDeviceId returns the device_id of the sensor a command targets, or an
+// empty string if the device is not a sensor. Devices without routing properties
+// are treated as sensors if they use the sensor device profile.
+func (driver *Driver) sensorDeviceId(deviceName string, protocols map[string]edgexModels.ProtocolProperties) string {
+	props := protocols[mqttProtocol]
+
+	deviceType := props[deviceTypeProperty]
+	if deviceType == "" {
+		deviceType = controllerDeviceType
+		if svc := sdk.RunningService(); svc != nil {
+			if device, err := svc.GetDeviceByName(deviceName); err == nil {
+				deviceType = deviceTypeForProfile(device.Profile.Name)
+			}
+		}
+	}
+
+	if deviceType != sensorDeviceType {
+		return ""
+	}
+	if deviceId := props[deviceIdProperty]; deviceId != "" {
+		return deviceId
+	}
+	return deviceName
+}
+
+// deviceController returns the RSP Controller that commands for the device are
+// sent to. Devices without a known controller id belong to the controller they
+// are named after or, if only one is configured, to the only controller.
+func (driver *Driver) deviceController(deviceName string, protocols map[string]edgexModels.ProtocolProperties) (*rspController, error) {
+	if controllerId, ok := protocols[mqttProtocol][controllerIdProperty]; ok {
+		if controller := driver.controllerById(controllerId); controller != nil {
+			return controller, nil
+		}
+	}
+	if controller := driver.controllerByDeviceName(deviceName); controller != nil {
+		return controller, nil
+	}
+	if len(driver.controllers) == 1 {
+		return driver.controllers[0], nil
+	}
+	return nil, errors.Errorf("unable to determine the RSP Controller of device %q", deviceName)
+}
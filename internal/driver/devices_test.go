@@ -0,0 +1,120 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package driver
+
+import (
+	edgexModels "github.com/edgexfoundry/go-mod-core-contracts/models"
+	expect "github.com/intel/rsp-sw-toolkit-im-suite-expect"
+	"github.com/pkg/errors"
+	"testing"
+)
+
+func TestFindDevice(t *testing.T) {
+	w := expect.WrapT(t)
+	d := &Driver{Config: &configuration{MqttScheme: "tcp"}}
+
+	devices := []edgexModels.Device{
+		{Name: "RSP-legacy"},
+		{Name: "renamed-sensor", Protocols: d.deviceProtocols("RSP-15077a", rspDeviceProfile, "")},
+		{Name: "RSP-15077b", Protocols: d.deviceProtocols("RSP-other", rspDeviceProfile, "")},
+	}
+
+	device, ok := findDevice(devices, "RSP-15077a")
+	w.As("by property").ShouldBeTrue(ok)
+	w.ShouldBeEqual(device.Name, "renamed-sensor")
+
+	device, ok = findDevice(devices, "RSP-legacy")
+	w.As("by name").ShouldBeTrue(ok)
+	w.ShouldBeEqual(device.Name, "RSP-legacy")
+
+	_, ok = findDevice(devices, "RSP-15077b")
+	w.As("name belongs to another sensor").ShouldBeFalse(ok)
+}
+
+func TestSensorDeviceId(t *testing.T) {
+	w := expect.WrapT(t)
+	d := &Driver{Config: &configuration{MqttScheme: "tcp"}}
+
+	w.As("renamed sensor").ShouldBeEqual(d.sensorDeviceId("renamed-sensor",
+		d.deviceProtocols("RSP-15077a", rspDeviceProfile, "")), "RSP-15077a")
+	w.As("controller named like a sensor").ShouldBeEqual(d.sensorDeviceId("RSP-controller",
+		d.deviceProtocols("RSP-controller", rspControllerDeviceProfile, "")), "")
+	w.As("missing device id").ShouldBeEqual(d.sensorDeviceId("RSP-15077a",
+		map[string]edgexModels.ProtocolProperties{
+			mqttProtocol: {deviceTypeProperty: sensorDeviceType},
+		}), "RSP-15077a")
+}
+
+func TestDeviceController(t *testing.T) {
+	w := expect.WrapT(t)
+	a := &rspController{Id: "a", DeviceName: "rsp-controller-a"}
+	b := &rspController{Id: "b", DeviceName: "rsp-controller-b"}
+	d := &Driver{Config: &configuration{}, controllers: []*rspController{a, b}}
+
+	w.As("sensor").ShouldBeEqual(w.ShouldHaveResult(d.deviceController("RSP-15077a",
+		d.deviceProtocols("RSP-15077a", rspDeviceProfile, "b"))), b)
+	w.As("controller").ShouldBeEqual(w.ShouldHaveResult(d.deviceController("rsp-controller-a",
+		nil)), a)
+	w.As("unknown").ShouldHaveError(d.deviceController("RSP-15077a", nil))
+
+	d.controllers = []*rspController{a}
+	w.As("only controller").ShouldBeEqual(w.ShouldHaveResult(d.deviceController("RSP-15077a",
+		nil)), a)
+}
+
+type fakeDeviceCache struct {
+	devices []edgexModels.Device
+	scans   int
+}
+
+func (c *fakeDeviceCache) GetDeviceByName(name string) (edgexModels.Device, error) {
+	for _, device := range c.devices {
+		if device.Name == name {
+			return device, nil
+		}
+	}
+	return edgexModels.Device{}, errors.Errorf("no device %s", name)
+}
+
+func (c *fakeDeviceCache) Devices() []edgexModels.Device {
+	c.scans++
+	return c.devices
+}
+
+func TestLookupDevice(t *testing.T) {
+	w := expect.WrapT(t)
+	d := &Driver{Config: &configuration{MqttScheme: "tcp"}}
+
+	cache := &fakeDeviceCache{devices: []edgexModels.Device{
+		{Name: "RSP-legacy"},
+		{Name: "RSP-15077a", Protocols: d.deviceProtocols("RSP-15077a", rspDeviceProfile, "")},
+		{Name: "renamed-sensor", Protocols: d.deviceProtocols("RSP-15077b", rspDeviceProfile, "")},
+	}}
+
+	device, ok := d.lookupDevice(cache, "RSP-15077a")
+	w.As("by name").ShouldBeTrue(ok)
+	w.ShouldBeEqual(device.Name, "RSP-15077a")
+	w.ShouldBeEqual(cache.scans, 0)
+
+	device, ok = d.lookupDevice(cache, "RSP-15077b")
+	w.As("renamed").ShouldBeTrue(ok)
+	w.ShouldBeEqual(device.Name, "renamed-sensor")
+	w.ShouldBeEqual(cache.scans, 1)
+
+	d.rememberSensor("RSP-15077b", "renamed-sensor", rspDeviceProfile)
+	device, ok = d.lookupDevice(cache, "RSP-15077b")
+	w.As("known sensor").ShouldBeTrue(ok)
+	w.ShouldBeEqual(device.Name, "renamed-sensor")
+	w.ShouldBeEqual(cache.scans, 1)
+
+	device, ok = d.lookupDevice(cache, "RSP-legacy")
+	w.As("legacy").ShouldBeTrue(ok)
+	w.ShouldBeEqual(device.Name, "RSP-legacy")
+
+	_, ok = d.lookupDevice(cache, "RSP-unknown")
+	w.As("unknown").ShouldBeFalse(ok)
+}
@@ -23,14 +23,13 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/eclipse/paho.mqtt.golang"
 	"github.com/edgexfoundry/device-sdk-go"
-	sdk "github.com/edgexfoundry/device-sdk-go"
 	sdkModel "github.com/edgexfoundry/device-sdk-go/pkg/models"
 	"github.com/edgexfoundry/go-mod-core-contracts/clients/logger"
-	edgexModels "github.com/edgexfoundry/go-mod-core-contracts/models"
 	"github.com/intel/rsp-sw-toolkit-im-suite-gojsonschema"
 )
 
@@ -40,8 +39,8 @@ const (
 	rspControllerDeviceProfile = "RSP.Controller.Device.MQTT.Profile"
 	rspDeviceProfile           = "RSP.Device.MQTT.Profile"
 	disconnectQuiesceMillis    = 5000
-	connectFailureSleep        = 5 * time.Second
-	subscribeFailureSleep      = 5 * time.Second
+	// how long to wait for the driver to give up once the shutdown deadline has passed
+	abortGracePeriod = 2 * time.Second
 	// maximum amount of incoming data mqtt messages to handle at one time
 	incomingDataMessageBuffer = 100
 	// maximum amount of incoming mqtt responses to handle at one time
@@ -60,34 +59,109 @@ type Driver struct {
 	Logger      logger.LoggingClient
 	AsyncCh     chan<- *sdkModel.AsyncValues
 	Config      *configuration
-	Client      mqtt.Client
+	Client      brokerClient
 	DecoderRing *DecoderRing
 
+	// brokers are the URIs of the MQTT brokers, in order of preference
+	brokers []*url.URL
+	// broker is the URI of the broker the Client connects to
+	broker *url.URL
+	// bridge carries the Client's connection to a ws or wss broker
+	bridge *websocketBridge
+	// retiredClient is the last client whose connection is being replaced, so it
+	// doesn't start another re-connection
+	retiredClient brokerClient
+	clientMutex   sync.RWMutex
+
+	// tlsConfig is used for ssl connections to the mqtt broker, through brokerTLSConfig
+	tlsConfig *tls.Config
+	// tlsFiles verifies the broker certificate if the TlsCaFile is used; otherwise it's nil
+	tlsFiles *tlsFiles
+	// mqtt5 is true if the broker is spoken to with MQTT 5 rather than 3.1.1
+	mqtt5 bool
+	// sharedPrefix makes the incoming subscriptions shared, if it isn't empty
+	sharedPrefix string
+
+	// retries are the connection and subscription retries in progress
+	retries retries
+
 	watchdogTimer  *time.Timer
 	watchdogStatus *time.Ticker
-
-	responseMap sync.Map // [string]chan *jsonrpc.Response
+	// watchdogStatusDone stops the periodicWatchdogStatus loop of the watchdogStatus
+	watchdogStatusDone chan struct{}
+	watchdogMutex      sync.Mutex
+	// watchdogDeadline is the time.Time the watchdogTimer fires at, or the zero time while it's stopped
+	watchdogDeadline atomic.Value
+
+	// connected is 1 while the driver is connected to the broker, accessed atomically
+	connected int32
+	// subscribed holds the topic filters subscribed to on the current connection
+	subscribed sync.Map // [string]bool
+	// operatingStateMutex serializes updating the OperatingState of the devices
+	operatingStateMutex sync.Mutex
+	// sensorHeartbeats track the sensors by device_id, controllerHeartbeats the controllers by device name
+	sensorHeartbeats     heartbeatTracker
+	controllerHeartbeats heartbeatTracker
+
+	// tagDecodeFailures counts the tag reads which couldn't be decoded, by the device_id of their sensor
+	tagDecodeFailures counters
+
+	responseMap sync.Map // [string]*pendingCommand
+	// sensorDevices maps the device_id of each registered sensor to its EdgeX device name
+	sensorDevices sync.Map // [string]string
+
+	// controllers are the RSP Controllers served by this driver
+	controllers []*rspController
 
 	// mqttDataChan is a channel to send incoming mqtt messages from any of the incoming topics
-	mqttDataChan chan mqtt.Message
-	// mqttResponseChan is a channel to only send incoming mqtt messages from the command response topic
-	mqttResponseChan chan mqtt.Message
-
-	started chan bool
-	done    chan interface{}
-
-	incomingSchemas map[string]*gojsonschema.Schema
-	responseSchemas map[string]*gojsonschema.Schema
+	mqttDataChan chan controllerMessage
+	// incomingWorkers are the channels of the workers processing messages from mqttDataChan
+	incomingWorkers []chan controllerMessage
+	// mqttResponseChan is a channel to only send incoming mqtt messages from the command response topics
+	mqttResponseChan chan controllerMessage
+
+	// started is closed once the initial connection to the broker is made
+	started   chan interface{}
+	startOnce sync.Once
+	// done is closed when the driver starts stopping; no new work is accepted after it
+	done     chan interface{}
+	stopOnce sync.Once
+	// abort is closed when the ShutdownWaitSeconds have passed, so anything
+	// still waiting to finish its work gives up
+	abort chan interface{}
+	// stopped is closed once the driver has finished its work and disconnected
+	stopped chan interface{}
+	// senders are the goroutines which may send to the AsyncCh
+	senders sync.WaitGroup
+
+	// healthState is the current healthState, accessed atomically
+	healthState int32
+	// failed is closed if the driver stops because of a failure
+	failed   chan interface{}
+	failOnce sync.Once
+	failure  error
+	// stopService asks the device service to stop after a failure; if nil,
+	// interruptProcess does
+	stopService func()
+
+	incomingSchemas sync.Map // [string]*gojsonschema.Schema
+	responseSchemas sync.Map // [string]*gojsonschema.Schema
+
+	// readingBuffer holds readings on disk while EdgeX core-data is unreachable;
+	// it is nil if the ReadingBufferDir isn't configured
+	readingBuffer *readingBuffer
+
+	// notificationValues are the typed readings declared by the device profiles,
+	// by profile name and notification method
+	notificationValues map[string]map[string][]notificationValue
 }
 
 // NewProtocolDriver returns the package-level driver instance.
 func NewProtocolDriver() sdkModel.ProtocolDriver {
 	once.Do(func() {
 		driverInstance = new(Driver)
-		driverInstance.mqttDataChan = make(chan mqtt.Message, incomingDataMessageBuffer)
-		driverInstance.mqttResponseChan = make(chan mqtt.Message, incomingResponseMessageBuffer)
-		driverInstance.incomingSchemas = make(map[string]*gojsonschema.Schema)
-		driverInstance.responseSchemas = make(map[string]*gojsonschema.Schema)
+		driverInstance.mqttDataChan = make(chan controllerMessage, incomingDataMessageBuffer)
+		driverInstance.mqttResponseChan = make(chan controllerMessage, incomingResponseMessageBuffer)
 	})
 	return driverInstance
 }
@@ -103,50 +177,134 @@ func (driver *Driver) Initialize(lc logger.LoggingClient, asyncCh chan<- *sdkMod
 	driver.Logger = lc
 	driver.AsyncCh = asyncCh
 
-	// driver.responseChan = make(chan *jsonrpc.Response)
-	driver.started = make(chan bool)
+	driver.started = make(chan interface{})
 	driver.done = make(chan interface{})
+	driver.abort = make(chan interface{})
+	driver.stopped = make(chan interface{})
+	driver.failed = make(chan interface{})
 
 	config, err := CreateDriverConfig(device.DriverConfigs())
 	if err != nil {
-		panic(errors.Wrap(err, "read MQTT driver configuration failed"))
+		return errors.Wrap(err, "read MQTT driver configuration failed")
 	}
 	if config.SchemasDir == "" {
 		return errors.New("schema directory must be set in configuration")
 	}
 	driver.Config = config
 
+	if driver.controllers, err = config.controllers(); err != nil {
+		return err
+	}
+
+	if driver.brokers, err = config.mqttBrokers(); err != nil {
+		return err
+	}
+
+	if err := config.validateRetry(); err != nil {
+		return err
+	}
+
+	if err := config.validateLiveness(); err != nil {
+		return err
+	}
+
+	if driver.mqtt5, err = config.usesMqtt5(); err != nil {
+		return err
+	}
+
+	if driver.sharedPrefix, err = config.sharedSubscriptionPrefix(); err != nil {
+		return err
+	}
+
+	if config.MqttStoreDir != "" {
+		if driver.mqtt5 {
+			lc.Warn("The MQTT 5 client has no message store; in flight messages are kept by the broker only",
+				MqttStoreDir, config.MqttStoreDir)
+		} else if err := os.MkdirAll(config.MqttStoreDir, 0700); err != nil {
+			// paho panics if it can't create the store directory itself
+			return errors.Wrapf(err, "unable to create MQTT store directory %q", config.MqttStoreDir)
+		}
+	}
+
+	if driver.tlsConfig, err = driver.createTLSConfig(); err != nil {
+		return err
+	}
+
 	if err := driver.setupDecoderRing(); err != nil {
 		return err
 	}
 
+	if driver.notificationValues, err = notificationValues(device.RunningService().DeviceProfiles()); err != nil {
+		return err
+	}
+
+	if config.ReadingBufferDir != "" {
+		driver.readingBuffer, err = newReadingBuffer(lc, config.ReadingBufferDir,
+			int64(config.ReadingBufferMaxBytes), time.Duration(config.ReadingBufferMaxAgeSeconds)*time.Second,
+			pingCoreData(config.CoreDataURL))
+		if err != nil {
+			return err
+		}
+		if n := driver.readingBuffer.Len(); n > 0 {
+			lc.Info("Sending readings buffered by a previous run", "batches", n)
+		}
+		driver.senders.Add(1)
+		go func() {
+			defer driver.senders.Done()
+			driver.readingBuffer.forward(asyncCh, driver.done)
+		}()
+	}
+
+	workers := config.incomingWorkerCount()
+	driver.startIncomingWorkers(workers, driver.onIncomingDataReceived)
+	lc.Info("Started incoming data workers", "workers", workers)
+
+	driver.watchLiveness()
+
 	driver.setupWatchdog()
 
+	if config.StatusAddress != "" {
+		if err := driver.startStatusServer(); err != nil {
+			return err
+		}
+	}
+
 	go driver.Start()
 
 	// wait for the initial connection before telling EdgeX we have been initialized
-	<-driver.started
-
-	return nil
+	select {
+	case <-driver.started:
+		return nil
+	case <-driver.failed:
+		return driver.failure
+	}
 }
 
 func (driver *Driver) Start() {
-	// make sure the RSP Controller device is present in the edgex database
-	driver.registerDeviceIfNeeded(driver.Config.ControllerName, rspControllerDeviceProfile)
+	// make sure the RSP Controller devices are present in the edgex database
+	for _, controller := range driver.controllers {
+		driver.registerDeviceIfNeeded(controller.DeviceName, rspControllerDeviceProfile, controller.Id)
+	}
 
-	driver.createClient()
-	go driver.connect()
+	go driver.connect(false)
+	if len(driver.brokers) > 1 && driver.Config.MqttFailbackSeconds > 0 {
+		go driver.failBackPeriodically()
+	}
 
 	driver.runUntilCancelled()
+	driver.stopWatchdog()
+	driver.finish()
 
-	driver.Logger.Warn("Disconnecting client from MQTT broker")
-	driver.Client.Disconnect(disconnectQuiesceMillis)
-	driver.Logger.Warn("Exiting...")
-	// Call this to make sure the process is actually stopped
-	os.Exit(0)
+	if client := driver.mqttClient(); client != nil {
+		driver.Logger.Warn("Disconnecting client from MQTT broker")
+		client.Disconnect(disconnectQuiesceMillis)
+	}
+	driver.closeBridge()
+	close(driver.stopped)
 }
 
-// runUntilCancelled will block forever until done is signaled or a timer is fired causing a panic()
+// runUntilCancelled will block until done is signaled. If the watchdog timer
+// fires first, the driver fails and the device service is asked to stop.
 func (driver *Driver) runUntilCancelled() {
 	for {
 		select {
@@ -154,28 +312,104 @@ func (driver *Driver) runUntilCancelled() {
 			driver.onCommandResponseReceived(msg)
 
 		case msg := <-driver.mqttDataChan:
-			driver.onIncomingDataReceived(msg)
+			driver.dispatchIncoming(msg)
 
 		case <-driver.done:
 			driver.Logger.Info("done signaled. stopping service.")
 			return
 
 		case <-driver.watchdogTimer.C:
-			panic(errors.New("Timed out waiting for mqtt client to connect/re-connect. Exiting..."))
+			driver.failConnection(healthWatchdogExpired, errors.Errorf(
+				"timed out after %d seconds waiting for mqtt client to connect/re-connect",
+				driver.Config.MaxReconnectWaitSeconds))
+		}
+	}
+}
+
+// finish completes the work already received once done is signaled: it stops
+// the subscriptions, drains the mqtt channels, waits for the incoming workers,
+// then fails the commands still waiting for a response. Anything left when
+// abort is signaled is dropped.
+func (driver *Driver) finish() {
+	driver.unsubscribeAll()
+
+	drained := false
+	for !drained {
+		select {
+		case msg := <-driver.mqttResponseChan:
+			driver.onCommandResponseReceived(msg)
+		case msg := <-driver.mqttDataChan:
+			driver.dispatchIncoming(msg)
+		case <-driver.abort:
+			driver.Logger.Warn("Shutdown deadline passed; dropping unprocessed mqtt messages",
+				"incoming", len(driver.mqttDataChan), "responses", len(driver.mqttResponseChan))
+			drained = true
+		default:
+			drained = true
 		}
 	}
+
+	driver.stopIncomingWorkers()
+	driver.failCommands(nil, errors.New("the device service is stopping"))
 }
 
 // Stop instructs the protocol-specific DS code to shutdown gracefully, or
-// if the force parameter is 'true', immediately. The driver is responsible
-// for closing any in-use channels, including the channel used to send async
-// readings (if supported).
+// if the force parameter is 'true', immediately.
+//
+// A graceful shutdown waits up to ShutdownWaitSeconds for received messages
+// to be processed and their readings to be sent. The AsyncCh is left open,
+// although the SDK asks drivers to close it: processAsyncResults of device-sdk-go
+// v1.0.0 is already waiting to receive from it when Stop is called, and keeps
+// doing so until the process exits, so it would panic on the nil it receives
+// from a closed channel.
 func (driver *Driver) Stop(force bool) error {
-	close(driver.done)
-	close(driver.AsyncCh)
+	driver.stopOnce.Do(func() {
+		driver.Logger.Info("Stopping the MQTT device service", "force", force)
+		driver.setHealth(healthStopping)
+
+		wait := time.Duration(driver.Config.ShutdownWaitSeconds) * time.Second
+		if force {
+			wait = 0
+		}
+		deadline := time.AfterFunc(wait, func() { close(driver.abort) })
+		defer deadline.Stop()
+
+		close(driver.done)
+
+		// once aborted, everything still running gives up promptly; give it a moment
+		giveUp := make(chan interface{})
+		giveUpTimer := time.AfterFunc(wait+abortGracePeriod, func() { close(giveUp) })
+		defer giveUpTimer.Stop()
+		select {
+		case <-driver.stopped:
+		case <-giveUp:
+			driver.Logger.Warn("Timed out waiting for the driver to stop")
+		}
+
+		if !waitGroupDone(&driver.senders, giveUp) {
+			driver.Logger.Warn("Timed out waiting for readings to be sent")
+		}
+		driver.Logger.Info("MQTT device service stopped", "health", driver.health().String())
+	})
 	return nil
 }
 
+// waitGroupDone waits for the WaitGroup and returns true, or returns false if
+// cancel is closed first
+func waitGroupDone(wg *sync.WaitGroup, cancel <-chan interface{}) bool {
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return true
+	case <-cancel:
+		return false
+	}
+}
+
 func (driver *Driver) setupWatchdog() {
 	// setup watchdog timer but immediately stop it (we require a non-nil timer)
 	driver.watchdogTimer = time.NewTimer(time.Duration(driver.Config.MaxReconnectWaitSeconds) * time.Second)
@@ -183,54 +417,117 @@ func (driver *Driver) setupWatchdog() {
 }
 
 func (driver *Driver) startWatchdog() {
+	driver.watchdogMutex.Lock()
+	defer driver.watchdogMutex.Unlock()
+
 	wait := time.Duration(driver.Config.MaxReconnectWaitSeconds) * time.Second
 	driver.watchdogTimer.Reset(wait)
+	driver.watchdogDeadline.Store(time.Now().Add(wait))
 
+	// connect starts the watchdog on every failover and failback, so only one status loop may run
+	driver.stopWatchdogStatus()
 	driver.watchdogStatus = time.NewTicker(wait / 10)
-	go driver.periodicWatchdogStatus(driver.watchdogStatus)
+	driver.watchdogStatusDone = make(chan struct{})
+	go driver.periodicWatchdogStatus(driver.watchdogStatus, driver.watchdogStatusDone)
 }
 
 func (driver *Driver) stopWatchdog() {
+	driver.watchdogMutex.Lock()
+	defer driver.watchdogMutex.Unlock()
+
 	driver.watchdogTimer.Stop()
-	if driver.watchdogStatus != nil {
-		driver.watchdogStatus.Stop()
+	driver.watchdogDeadline.Store(time.Time{})
+	driver.stopWatchdogStatus()
+}
+
+// stopWatchdogStatus stops the periodicWatchdogStatus loop, if it's running.
+// The caller must hold the watchdogMutex.
+func (driver *Driver) stopWatchdogStatus() {
+	if driver.watchdogStatus == nil {
+		return
 	}
+	driver.watchdogStatus.Stop()
+	close(driver.watchdogStatusDone)
+	driver.watchdogStatus = nil
+	driver.watchdogStatusDone = nil
 }
 
-func (driver *Driver) onMqttConnectionLost(client mqtt.Client, e error) {
-	driver.Logger.Warn("MQTT connection lost", "cause", e.Error())
+func (driver *Driver) onMqttConnectionLost(client brokerClient, e error) {
+	if client != driver.mqttClient() {
+		// a client replaced during failover
+		return
+	}
+	driver.Logger.Warn("MQTT connection lost", "broker", driver.activeBroker().String(), "cause", e.Error())
+	driver.setConnected(false)
+	go driver.updateOperatingStates()
+
+	if !driver.autoReconnect() {
+		select {
+		case <-driver.done:
+			return
+		default:
+		}
+		if !driver.retireClient(client) {
+			// already being replaced by a failback
+			return
+		}
+		driver.Logger.Warn("Connecting to the first available MQTT broker...")
+		driver.setHealth(healthReconnecting)
+		go driver.connect(true)
+		return
+	}
 
 	// IsConnected returns true if we are trying to reconnect still
 	if client.IsConnected() {
 		driver.Logger.Warn("Attempting to auto reconnect to MQTT broker...")
+		driver.setHealth(healthReconnecting)
 		driver.startWatchdog()
 	} else {
-		panic(errors.Wrap(e, "Connection to MQTT broker has been lost, and does not appear to be auto re-connecting"))
+		driver.failConnection(healthConnectionFailed, errors.Wrap(e,
+			"connection to MQTT broker has been lost, and does not appear to be auto re-connecting"))
 	}
 }
 
-// periodicWatchdogStatus will print a status message every so often to let the user know we are still waiting
-func (driver *Driver) periodicWatchdogStatus(watchdogStatus *time.Ticker) {
-	for range watchdogStatus.C {
-		driver.Logger.Warn("still waiting for a connection to MQTT broker...")
+// periodicWatchdogStatus will print a status message every so often to let the user know we are still waiting,
+// until done is closed
+func (driver *Driver) periodicWatchdogStatus(watchdogStatus *time.Ticker, done <-chan struct{}) {
+	for {
+		select {
+		case <-watchdogStatus.C:
+			retries := driver.retries.snapshot()
+			driver.Logger.Warn("still waiting for a connection to MQTT broker...",
+				"retries", retries.ConnectRetries, "nextAttempt", retries.NextConnect.Format(time.RFC3339))
+		case <-done:
+			return
+		}
 	}
 }
 
-func (driver *Driver) onMqttConnect(client mqtt.Client) {
+func (driver *Driver) onMqttConnect(client brokerClient) {
 	driver.stopWatchdog()
 
-	driver.Logger.Info("MQTT client connected/re-connected successfully")
+	driver.Logger.Info("MQTT client connected/re-connected successfully", "broker", driver.activeBroker().String())
+	driver.restartLiveness()
+	driver.setConnected(true)
+	go driver.updateOperatingStates()
 
 	driver.subscribeAll()
 
-	driver.configureControllerNotifications()
+	for _, controller := range driver.controllers {
+		driver.configureControllerNotifications(controller)
+	}
 
-	driver.started <- true
+	driver.setHealth(healthConnected)
+	driver.startOnce.Do(func() { close(driver.started) })
 }
 
 // subscribe attempts to subscribe to a specific mqtt topic with a given qos and handler
-// it will try forever until it succeeds or is cancelled. should be called in a goroutine
+// it will try forever, with a growing wait between attempts, until it succeeds or is
+// cancelled. should be called in a goroutine
 func (driver *Driver) subscribe(topic string, qos byte, handler mqtt.MessageHandler) {
+	defer driver.retries.subscribed(topic)
+	backoff := driver.Config.newBackoff()
+
 	for {
 		// keep trying to subscribe forever unless done is signaled
 		select {
@@ -240,17 +537,24 @@ func (driver *Driver) subscribe(topic string, qos byte, handler mqtt.MessageHand
 			return
 
 		default:
-			token := driver.Client.Subscribe(topic, qos, handler)
-			if token.Wait() && token.Error() != nil {
-				driver.Logger.Warn("subscription error", "cause", token.Error(), "topic", topic, "qos", qos)
-			} else {
+			token := driver.mqttClient().Subscribe(topic, qos, handler)
+			if token.Wait() && token.Error() == nil {
 				driver.Logger.Info("subscription successful", "topic", topic, "qos", qos)
+				driver.subscribed.Store(topic, true)
 				// get out of the infinite loop
 				return
 			}
-		}
 
-		time.Sleep(subscribeFailureSleep)
+			wait := backoff.next()
+			driver.retries.subscribeFailed(topic, backoff.attempts)
+			driver.Logger.Warn("subscription error", "cause", token.Error(), "topic", topic, "qos", qos,
+				"wait", wait.String(), "retry", backoff.attempts)
+
+			select {
+			case <-time.After(wait):
+			case <-driver.done:
+			}
+		}
 	}
 }
 
@@ -260,112 +564,100 @@ func (driver *Driver) subscribe(topic string, qos byte, handler mqtt.MessageHand
 func (driver *Driver) subscribeAll() {
 	// subscriptions are done in goroutines to allow them to retry over and over again
 	// without interrupting the flow of the program
-
-	// response subscription
-	go driver.subscribe(driver.Config.ResponseTopic, driver.Config.ResponseQos, func(_ mqtt.Client, message mqtt.Message) {
-		driver.mqttResponseChan <- message
-	})
-
-	// incoming subscriptions
-	for _, topic := range driver.Config.IncomingTopics {
-		go driver.subscribe(topic, driver.Config.IncomingQos, func(_ mqtt.Client, message mqtt.Message) {
-			driver.mqttDataChan <- message
-		})
+	for _, s := range driver.subscriptions() {
+		go driver.subscribe(s.filter, s.qos, s.handler)
 	}
 }
 
-// createClient creates an MQTT client based on the driver config but does not connect it yet
-func (driver *Driver) createClient() {
-	opts := mqtt.NewClientOptions()
-
-	uri := &url.URL{
-		Scheme: strings.ToLower(driver.Config.MqttScheme),
-		Host:   fmt.Sprintf("%s:%s", driver.Config.MqttHost, driver.Config.MqttPort),
-	}
-
-	// use `append()` because `opts.AddBroker()` does superfluous url parsing
-	opts.Servers = append(opts.Servers, uri)
-
-	opts.SetClientID(driver.Config.MqttClientId)
-	opts.SetUsername(driver.Config.MqttUser)
-	opts.SetPassword(driver.Config.MqttPassword)
-	opts.SetKeepAlive(time.Second * time.Duration(driver.Config.MqttKeepAlive))
-	opts.SetTLSConfig(&tls.Config{InsecureSkipVerify: driver.Config.TlsInsecureSkipVerify})
-	// just let the mqtt library handle reconnecting for us
-	opts.SetAutoReconnect(true)
-
-	opts.SetConnectionLostHandler(driver.onMqttConnectionLost)
-	opts.SetOnConnectHandler(driver.onMqttConnect)
-
-	driver.Logger.Info("Create MQTT client", "uri",
-		uri.String(), "clientId", driver.Config.MqttClientId)
-
-	driver.Client = mqtt.NewClient(opts)
+// subscription is a topic filter the driver subscribes to, and the handler of its messages
+type subscription struct {
+	filter  string
+	qos     byte
+	handler mqtt.MessageHandler
 }
 
-// connect tries multiple times to establish the initial connection to the mqtt broker
-// This is NOT called when re-connecting!
-// MUST call createClient first!
-func (driver *Driver) connect() {
-	driver.startWatchdog()
-
-	for {
-		driver.Logger.Info("attempting to establish connection to mqtt broker...")
-		token := driver.Client.Connect()
-		if token.Wait() && token.Error() == nil {
-			driver.Logger.Info("mqtt connection successful")
-			return
+// subscriptions returns the response and incoming subscriptions of all controllers
+func (driver *Driver) subscriptions() []subscription {
+	var subscriptions []subscription
+	for _, controller := range driver.controllers {
+		controller := controller
+
+		for _, topic := range driver.responseTopics(controller) {
+			subscriptions = append(subscriptions, subscription{topic, driver.Config.ResponseQos,
+				func(_ mqtt.Client, message mqtt.Message) {
+					select {
+					case driver.mqttResponseChan <- controllerMessage{Message: message, controller: controller}:
+					case <-driver.done:
+					}
+				}})
 		}
 
-		driver.Logger.Error("unable to connect to mqtt broker", "cause", token.Error())
-		driver.Logger.Info(fmt.Sprintf("attempting to connect to mqtt broker again in %v...", connectFailureSleep))
-		time.Sleep(connectFailureSleep)
+		for _, filter := range driver.incomingFilters(controller) {
+			subscriptions = append(subscriptions, subscription{filter, driver.Config.IncomingQos,
+				func(_ mqtt.Client, message mqtt.Message) {
+					select {
+					case driver.mqttDataChan <- controllerMessage{Message: message, controller: controller}:
+					case <-driver.done:
+					}
+				}})
+		}
 	}
+	return subscriptions
 }
 
-// registerDeviceIfNeeded registers an MQTT device with EdgeX for the purposes of calling commands and receiving data
-func (driver *Driver) registerDeviceIfNeeded(deviceId string, profileName string) {
-	if _, err := sdk.RunningService().GetDeviceByName(deviceId); err == nil {
-		// if err is nil, device already exists
-		driver.Logger.Debug("Device already exists, not registering", "deviceId", deviceId, "profile", profileName)
+// unsubscribeAll removes the subscriptions of all controllers so no new messages arrive
+func (driver *Driver) unsubscribeAll() {
+	client := driver.mqttClient()
+	if client == nil || !client.IsConnected() {
 		return
 	}
 
-	driver.Logger.Debug("Device not found in EdgeX database. Now Registering.", "deviceId", deviceId, "profile", profileName)
-	_, err := sdk.RunningService().AddDevice(edgexModels.Device{
-		Name:           deviceId,
-		AdminState:     edgexModels.Unlocked,
-		OperatingState: edgexModels.Enabled,
-		Protocols: map[string]edgexModels.ProtocolProperties{
-			"mqtt": {
-				"Scheme": driver.Config.MqttScheme,
-			},
-		},
-		Profile: edgexModels.DeviceProfile{
-			Name: profileName,
-		},
-	})
-	if err != nil {
-		driver.Logger.Error("Device registration failed",
-			"device", deviceId, "profile", profileName, "cause", err)
+	var topics []string
+	for _, s := range driver.subscriptions() {
+		topics = append(topics, s.filter)
+	}
+
+	token := client.Unsubscribe(topics...)
+	if !token.WaitTimeout(disconnectQuiesceMillis * time.Millisecond) {
+		driver.Logger.Warn("Timed out unsubscribing from mqtt topics")
+	} else if token.Error() != nil {
+		driver.Logger.Warn("Unable to unsubscribe from mqtt topics", "cause", token.Error().Error())
 	}
 }
 
 func (driver *Driver) setupDecoderRing() error {
+	if err := driver.Config.validateTagDecoding(); err != nil {
+		return err
+	}
 	driver.DecoderRing = &DecoderRing{}
 	for idx, f := range driver.Config.TagFormats {
 		switch strings.ToLower(f) {
 		case "bittag":
+			headers, err := parseTagHeaders(driver.Config.TagBitTagHeaders)
+			if err != nil {
+				return err
+			}
 			if err := driver.DecoderRing.AddBitTagDecoder(
 				driver.Config.TagURIAuthorityName,
 				driver.Config.TagURIAuthorityDate,
-				driver.Config.TagBitBoundary); err != nil {
+				driver.Config.TagBitBoundary,
+				headers...); err != nil {
 				return err
 			}
 		case "sgtin":
 			driver.DecoderRing.AddSGTINDecoder(driver.Config.SGTINStrictDecoding)
+		case "sscc", "sgln", "grai", "giai":
+			if err := driver.DecoderRing.AddEPCDecoder(f, driver.Config.EPCStrictDecoding); err != nil {
+				return err
+			}
 		default:
-			return errors.Errorf("Unknown tag format: %s", f)
+			layout, ok := driver.Config.customTagLayouts[f]
+			if !ok {
+				return errors.Errorf("Unknown tag format: %s", f)
+			}
+			if err := driver.DecoderRing.addCustomDecoder(layout); err != nil {
+				return err
+			}
 		}
 		driver.Logger.Info("Added tag data decoder", "format", f,
 			"details", fmt.Sprintf("%+v", driver.DecoderRing.Decoders[idx]),
@@ -375,52 +667,40 @@ func (driver *Driver) setupDecoderRing() error {
 }
 
 // configureControllerNotifications tells the RSP Controller which notifications it should send over MQTT
-func (driver *Driver) configureControllerNotifications() {
+func (driver *Driver) configureControllerNotifications(controller *rspController) {
 	// tell the RSP Controller what notifications we would like to receive
 	if driver.Config.RspControllerNotifications != nil && len(driver.Config.RspControllerNotifications) > 0 {
-		if err := driver.publishCommand(
-			jsonrpc.NewRSPControllerSubscribeRequest(driver.Config.RspControllerNotifications)); err != nil {
+		request := jsonrpc.NewRSPControllerSubscribeRequest(driver.Config.RspControllerNotifications)
+		if err := driver.publishCommand(controller, request, request.Id); err != nil {
 			driver.Logger.Warn("unable to subscribe to rsp controller notifications",
-				"cause", err.Error())
+				"controller", controller.DeviceName, "cause", err.Error())
 		}
 	}
 }
 
 // validateIncoming checks the data against the matching incoming schema.
 func (driver *Driver) validateIncoming(method string, data []byte) error {
-	schema, ok := driver.incomingSchemas[method]
-	if !ok {
-		var err error
-		schema, err = driver.loadSchema(incomingDir, method)
-		if err != nil {
-			return err
-		}
-		driver.incomingSchemas[method] = schema
-	}
-
-	result, err := schema.Validate(gojsonschema.NewBytesLoader(data))
-	if err != nil {
-		return errors.Wrapf(err, "unable to validate schema for method %q", method)
-	}
-	if !result.Valid() {
-		return errors.Errorf("JSON validation failed for %q: %+v", method, result.Errors())
-	}
-	return nil
+	return driver.validate(&driver.incomingSchemas, incomingDir, method, data)
 }
 
 // validateResponse checks the data against the matching response schema.
 func (driver *Driver) validateResponse(method string, data []byte) error {
-	schema, ok := driver.responseSchemas[method]
+	return driver.validate(&driver.responseSchemas, responsesDir, method, data)
+}
+
+// validate checks the data against the schema of the method, loading it into
+// the cache the first time it's used. It's safe to call concurrently.
+func (driver *Driver) validate(schemas *sync.Map, subDir, method string, data []byte) error {
+	cached, ok := schemas.Load(method)
 	if !ok {
-		var err error
-		schema, err = driver.loadSchema(responsesDir, method)
+		schema, err := driver.loadSchema(subDir, method)
 		if err != nil {
 			return err
 		}
-		driver.responseSchemas[method] = schema
+		cached, _ = schemas.LoadOrStore(method, schema)
 	}
 
-	result, err := schema.Validate(gojsonschema.NewBytesLoader(data))
+	result, err := cached.(*gojsonschema.Schema).Validate(gojsonschema.NewBytesLoader(data))
 	if err != nil {
 		return errors.Wrapf(err, "unable to validate schema for method %q", method)
 	}
@@ -0,0 +1,210 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package driver
+
+import (
+	"fmt"
+	"github.com/intel/rsp-sw-toolkit-im-suite-tagcode/bitextract"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+)
+
+const (
+	epc96NumBytes = 12
+
+	epcFilterStartBit    = 8
+	epcFilterLen         = 3
+	epcPartitionStartBit = epcFilterStartBit + epcFilterLen
+	epcPartitionLen      = 3
+	epcCompanyStartBit   = epcPartitionStartBit + epcPartitionLen
+)
+
+// epcCompanyBits are the bit lengths of the GS1 company prefix by partition;
+// the prefix has 12 - partition digits
+var epcCompanyBits = [7]int{40, 37, 34, 30, 27, 24, 20}
+
+var (
+	epcFilterExt    = bitextract.New(epcFilterStartBit, epcFilterLen)
+	epcPartitionExt = bitextract.New(epcPartitionStartBit, epcPartitionLen)
+)
+
+// epcScheme is the layout of a 96 bit GS1 EPC scheme which, like SGTIN-96, has a
+// header, filter, partition, company prefix and a reference following it
+type epcScheme struct {
+	name      string
+	header    byte
+	uriPrefix string
+	// refBits and refDigits are the bit and digit lengths of the reference by partition
+	refBits   [7]int
+	refDigits [7]int
+	// padRef is true if the reference is padded with leading zeros to refDigits
+	padRef bool
+	// serialBits is the bit length of the numeric serial following the reference, if any
+	serialBits int
+	// reservedBits is the bit length of trailing bits which must be zero, if any
+	reservedBits int
+	// elements returns the GS1 keys of the EPC from its zero padded company prefix
+	// and reference, and its serial
+	elements func(company, ref string, serial uint64) []GS1Element
+}
+
+// epcSchemes are the 96 bit GS1 EPC schemes by their TagFormats name
+var epcSchemes = map[string]epcScheme{
+	"sscc": {
+		name:         "SSCC",
+		header:       0x31,
+		uriPrefix:    "urn:epc:id:sscc",
+		refBits:      [7]int{18, 21, 24, 28, 31, 34, 38},
+		refDigits:    [7]int{5, 6, 7, 8, 9, 10, 11},
+		padRef:       true,
+		reservedBits: 24,
+		elements: func(company, ref string, serial uint64) []GS1Element {
+			// the first digit of the serial reference is the extension digit of the SSCC
+			return []GS1Element{{"00", withCheckDigit(ref[:1] + company + ref[1:])}}
+		},
+	},
+	"sgln": {
+		name:       "SGLN",
+		header:     0x32,
+		uriPrefix:  "urn:epc:id:sgln",
+		refBits:    [7]int{1, 4, 7, 11, 14, 17, 21},
+		refDigits:  [7]int{0, 1, 2, 3, 4, 5, 6},
+		padRef:     true,
+		serialBits: 41,
+		elements: func(company, ref string, serial uint64) []GS1Element {
+			elements := []GS1Element{{"414", withCheckDigit(company + ref)}}
+			// an extension of 0 stands for the GLN without extension
+			if serial != 0 {
+				elements = append(elements, GS1Element{"254", strconv.FormatUint(serial, 10)})
+			}
+			return elements
+		},
+	},
+	"grai": {
+		name:       "GRAI",
+		header:     0x33,
+		uriPrefix:  "urn:epc:id:grai",
+		refBits:    [7]int{4, 7, 10, 14, 17, 20, 24},
+		refDigits:  [7]int{0, 1, 2, 3, 4, 5, 6},
+		padRef:     true,
+		serialBits: 38,
+		elements: func(company, ref string, serial uint64) []GS1Element {
+			return []GS1Element{{"8003", "0" + withCheckDigit(company+ref) + strconv.FormatUint(serial, 10)}}
+		},
+	},
+	"giai": {
+		name:      "GIAI",
+		header:    0x34,
+		uriPrefix: "urn:epc:id:giai",
+		refBits:   [7]int{42, 45, 48, 52, 55, 58, 62},
+		refDigits: [7]int{13, 14, 15, 16, 17, 18, 19},
+		elements: func(company, ref string, serial uint64) []GS1Element {
+			return []GS1Element{{"8004", company + ref}}
+		},
+	},
+}
+
+// maxDecimal returns the largest number with the given number of digits
+func maxDecimal(digits int) uint64 {
+	max := uint64(1)
+	for i := 0; i < digits; i++ {
+		max *= 10
+	}
+	return max - 1
+}
+
+// decode converts the 96 bit EPC; if strict, its values must be within the
+// ranges of the EPC Tag Data Standard
+func (scheme *epcScheme) decode(tagData []byte, strict bool) (DecodedTag, error) {
+	if len(tagData) != epc96NumBytes {
+		return DecodedTag{}, errors.Errorf("%s-96 should have %d bytes, but this has %d bytes",
+			scheme.name, epc96NumBytes, len(tagData))
+	}
+	if tagData[0] != scheme.header {
+		return DecodedTag{}, errors.Errorf("%s-96 header is %#X, but this is: %#X",
+			scheme.name, scheme.header, tagData[0])
+	}
+
+	partition := int(epcPartitionExt.ExtractUInt64(tagData))
+	if partition > 6 {
+		return DecodedTag{}, errors.Errorf("invalid partition: %d", partition)
+	}
+	companyBits, refBits := epcCompanyBits[partition], scheme.refBits[partition]
+	companyDigits, refDigits := 12-partition, scheme.refDigits[partition]
+
+	pos := epcCompanyStartBit
+	extract := func(bits int) uint64 {
+		value := bitextract.New(pos, bits).ExtractUInt64(tagData)
+		pos += bits
+		return value
+	}
+	company := extract(companyBits)
+	ref := extract(refBits)
+	var serial, reserved uint64
+	if scheme.serialBits > 0 {
+		serial = extract(scheme.serialBits)
+	}
+	if scheme.reservedBits > 0 {
+		reserved = extract(scheme.reservedBits)
+	}
+
+	if strict {
+		if company > maxDecimal(companyDigits) {
+			return DecodedTag{}, errors.Errorf("company prefix in partition %d must be in [0, %d], "+
+				"but is %d", partition, maxDecimal(companyDigits), company)
+		}
+		if ref > maxDecimal(refDigits) {
+			return DecodedTag{}, errors.Errorf("%s reference in partition %d must be in [0, %d], "+
+				"but is %d", scheme.name, partition, maxDecimal(refDigits), ref)
+		}
+		if reserved != 0 {
+			return DecodedTag{}, errors.Errorf("%s-96 reserved bits must be 0, but are %d", scheme.name, reserved)
+		}
+	}
+
+	companyStr := fmt.Sprintf("%0*d", companyDigits, company)
+	var refStr string
+	switch {
+	case !scheme.padRef:
+		refStr = strconv.FormatUint(ref, 10)
+	case refDigits > 0 || ref != 0:
+		refStr = fmt.Sprintf("%0*d", refDigits, ref)
+	}
+	body := companyStr + "." + refStr
+	if scheme.serialBits > 0 {
+		body += "." + strconv.FormatUint(serial, 10)
+	}
+
+	return DecodedTag{
+		URI: scheme.uriPrefix + ":" + body,
+		TagURI: fmt.Sprintf("urn:epc:tag:%s-96:%d.%s",
+			strings.ToLower(scheme.name), epcFilterExt.ExtractUInt64(tagData), body),
+		Elements: scheme.elements(companyStr, refStr, serial),
+	}, nil
+}
+
+// AddEPCDecoder adds a decoder of the 96 bit GS1 EPC scheme of the format, i.e. sscc,
+// sgln, grai or giai; if strict, their values must be within the ranges of the EPC
+// Tag Data Standard
+func (dr *DecoderRing) AddEPCDecoder(format string, strict bool) error {
+	scheme, ok := epcSchemes[strings.ToLower(format)]
+	if !ok {
+		return errors.Errorf("unknown EPC scheme: %s", format)
+	}
+
+	decoder := func(tagData []byte) (DecodedTag, error) {
+		return scheme.decode(tagData, strict)
+	}
+
+	dr.Decoders = append(dr.Decoders, NamedDecoder{
+		Name:       scheme.name,
+		TagDecoder: decoder,
+		Headers:    []byte{scheme.header},
+	})
+	return nil
+}
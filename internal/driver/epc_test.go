@@ -0,0 +1,81 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package driver
+
+import (
+	expect "github.com/intel/rsp-sw-toolkit-im-suite-expect"
+	"testing"
+)
+
+func TestDecoderRing_EPCSchemes(t *testing.T) {
+	w := expect.WrapT(t)
+
+	dr := DecoderRing{}
+	dr.AddSGTINDecoder(true)
+	for _, format := range []string{"sscc", "sgln", "grai", "GIAI"} {
+		w.ShouldSucceed(dr.AddEPCDecoder(format, true))
+	}
+	w.ShouldFail(dr.AddEPCDecoder("sgtin", true))
+
+	for tagData, expectURI := range map[string]string{
+		"3174257BF4499602D2000000": "urn:epc:id:sscc:0614141.1234567890",
+		"3274257BF460720000000190": "urn:epc:id:sgln:0614141.12345.400",
+		"32023BF69FE5000000000000": "urn:epc:id:sgln:614141000000..0",
+		"3374257BF40C0E4000000190": "urn:epc:id:grai:0614141.12345.400",
+		"3474257BF400000000BC6038": "urn:epc:id:giai:0614141.12345400",
+		// SGTIN-198 is decoded by the sgtin decoder
+		"36143639F8419198B966E1AB366E5B3470DC00000000000000": "urn:epc:id:sgtin:0888446.067142.193853396487",
+	} {
+		w.As(tagData).ShouldBeEqual(w.ShouldHaveResult(dr.TagDataToURI(tagData)), expectURI)
+	}
+
+	w.As("reserved bits").ShouldHaveError(dr.TagDataToURI("3174257BF4499602D2000001"))
+	w.As("reference out of range").ShouldHaveError(dr.TagDataToURI("3174257BF6DFDC1C35000000"))
+	w.As("invalid partition").ShouldHaveError(dr.TagDataToURI("317C257BF4499602D2000000"))
+	w.As("not enough data").ShouldHaveError(dr.TagDataToURI("3174257BF4499602D20000"))
+}
+
+func TestDecoderRing_EPCSchemesNotStrict(t *testing.T) {
+	w := expect.WrapT(t)
+
+	dr := DecoderRing{}
+	w.ShouldSucceed(dr.AddEPCDecoder("sscc", false))
+
+	w.ShouldBeEqual(w.ShouldHaveResult(dr.TagDataToURI("3174257BF4499602D2000001")),
+		"urn:epc:id:sscc:0614141.1234567890")
+	w.ShouldBeEqual(w.ShouldHaveResult(dr.TagDataToURI("3174257BF6DFDC1C35000000")),
+		"urn:epc:id:sscc:0614141.12345678901")
+	w.As("invalid partition").ShouldHaveError(dr.TagDataToURI("317C257BF4499602D2000000"))
+}
+
+func TestDecoderRing_EPCRepresentations(t *testing.T) {
+	w := expect.WrapT(t)
+
+	dr := DecoderRing{}
+	for _, format := range []string{"sscc", "sgln", "grai", "giai"} {
+		w.ShouldSucceed(dr.AddEPCDecoder(format, true))
+	}
+
+	for tagData, expected := range map[string]struct{ tagURI, elementString, digitalLink string }{
+		"3174257BF4499602D2000000": {"urn:epc:tag:sscc-96:3.0614141.1234567890",
+			"(00)106141412345678908", "https://id.gs1.org/00/106141412345678908"},
+		"3274257BF460720000000190": {"urn:epc:tag:sgln-96:3.0614141.12345.400",
+			"(414)0614141123452(254)400", "https://id.gs1.org/414/0614141123452/254/400"},
+		"32023BF69FE5000000000000": {"urn:epc:tag:sgln-96:0.614141000000..0",
+			"(414)6141410000007", "https://id.gs1.org/414/6141410000007"},
+		"3374257BF40C0E4000000190": {"urn:epc:tag:grai-96:3.0614141.12345.400",
+			"(8003)00614141123452400", "https://id.gs1.org/8003/00614141123452400"},
+		"3474257BF400000000BC6038": {"urn:epc:tag:giai-96:3.0614141.12345400",
+			"(8004)061414112345400", "https://id.gs1.org/8004/061414112345400"},
+	} {
+		tag, _, err := dr.Decode(tagData)
+		w.As(tagData).StopOnMismatch().ShouldSucceed(err)
+		w.As(tagData).ShouldBeEqual(tag.TagURI, expected.tagURI)
+		w.As(tagData).ShouldBeEqual(tag.ElementString(), expected.elementString)
+		w.As(tagData).ShouldBeEqual(tag.DigitalLink("https://id.gs1.org"), expected.digitalLink)
+	}
+}
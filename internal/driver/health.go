@@ -0,0 +1,119 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package driver
+
+import (
+	"os"
+	"sync/atomic"
+	"syscall"
+)
+
+// healthState describes whether the driver is able to do its job
+type healthState int32
+
+const (
+	healthStarting healthState = iota
+	healthConnected
+	healthReconnecting
+	healthStopping
+	// failure states; the service exits with a non-zero code once it's stopped
+	healthWatchdogExpired
+	healthConnectionFailed
+)
+
+func (s healthState) String() string {
+	switch s {
+	case healthStarting:
+		return "starting"
+	case healthConnected:
+		return "connected"
+	case healthReconnecting:
+		return "reconnecting"
+	case healthStopping:
+		return "stopping"
+	case healthWatchdogExpired:
+		return "watchdog expired"
+	case healthConnectionFailed:
+		return "connection failed"
+	}
+	return "unknown"
+}
+
+// health returns the current health state of the driver
+func (driver *Driver) health() healthState {
+	return healthState(atomic.LoadInt32(&driver.healthState))
+}
+
+// setHealth updates the health state of the driver, unless it has already failed
+func (driver *Driver) setHealth(state healthState) {
+	for {
+		current := atomic.LoadInt32(&driver.healthState)
+		if healthState(current) >= healthWatchdogExpired {
+			return
+		}
+		if atomic.CompareAndSwapInt32(&driver.healthState, current, int32(state)) {
+			if healthState(current) != state {
+				driver.Logger.Info("Driver health changed", "from", healthState(current).String(), "to", state.String())
+			}
+			return
+		}
+	}
+}
+
+// failConnection puts the driver in a failure state and asks the device service
+// to stop, because the connection to the broker can't be (re-)established. It's
+// only meant for the watchdog and connection lost paths; other errors are
+// returned or logged. If the driver hasn't finished initializing, Initialize
+// returns the error instead.
+func (driver *Driver) failConnection(state healthState, err error) {
+	driver.setHealth(state)
+	driver.Logger.Error("Stopping the device service", "health", state.String(), "cause", err.Error())
+
+	driver.failOnce.Do(func() {
+		driver.failure = err
+		close(driver.failed)
+	})
+
+	select {
+	case <-driver.started:
+		if driver.stopService != nil {
+			driver.stopService()
+		} else {
+			interruptProcess()
+		}
+	default:
+	}
+}
+
+// interruptProcess asks the device service to stop by sending SIGTERM to this
+// process, which is the only way to do so with device-sdk-go v1.0.0; see ExitCode
+func interruptProcess() {
+	if p, err := os.FindProcess(os.Getpid()); err == nil {
+		_ = p.Signal(syscall.SIGTERM)
+	}
+}
+
+// ExitCode returns the code the process should exit with once the device service
+// has stopped: 1 if the driver stopped because of a failure, otherwise 0.
+//
+// Drivers can't stop the service of device-sdk-go v1.0.0 themselves, so on a
+// failure, the driver sends SIGTERM to its own process. That relies on
+// startup.Bootstrap catching SIGINT and SIGTERM, calling Service.Stop, which stops
+// the driver, and returning, so main can exit with this code. If a later SDK
+// stops handling those signals, the process is terminated with the default
+// SIGTERM status instead.
+func ExitCode() int {
+	if driverInstance == nil || driverInstance.failed == nil {
+		return 0
+	}
+	select {
+	case <-driverInstance.failed:
+		return 1
+	default:
+		return 0
+	}
+}
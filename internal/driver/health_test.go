@@ -0,0 +1,132 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package driver
+
+import (
+	sdkModel "github.com/edgexfoundry/device-sdk-go/pkg/models"
+	"github.com/edgexfoundry/go-mod-core-contracts/clients/logger"
+	expect "github.com/intel/rsp-sw-toolkit-im-suite-expect"
+	"github.com/pkg/errors"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestHealth(t *testing.T) {
+	w := expect.WrapT(t)
+	d := &Driver{
+		Logger:  logger.NewClient("test", false, "", "DEBUG"),
+		started: make(chan interface{}),
+		failed:  make(chan interface{}),
+	}
+
+	w.ShouldBeEqual(d.health(), healthStarting)
+	d.setHealth(healthConnected)
+	w.ShouldBeEqual(d.health(), healthConnected)
+	d.setHealth(healthReconnecting)
+	w.ShouldBeEqual(d.health(), healthReconnecting)
+
+	// not started yet, so it's reported by Initialize instead of stopping the service
+	err := errors.New("timed out")
+	d.failConnection(healthWatchdogExpired, err)
+	w.ShouldBeEqual(d.health(), healthWatchdogExpired)
+	w.ShouldBeEqual(d.failure, err)
+	select {
+	case <-d.failed:
+	default:
+		t.Error("expected failed to be closed")
+	}
+
+	// the first failure sticks
+	d.setHealth(healthStopping)
+	d.failConnection(healthConnectionFailed, errors.New("lost"))
+	w.ShouldBeEqual(d.health(), healthWatchdogExpired)
+	w.ShouldBeEqual(d.failure, err)
+
+	// once started, the device service is asked to stop
+	stops := 0
+	d.stopService = func() { stops++ }
+	close(d.started)
+	d.failConnection(healthConnectionFailed, errors.New("lost"))
+	w.ShouldBeEqual(stops, 1)
+}
+
+// sdkReceiveLoop receives from the AsyncCh like processAsyncResults of device-sdk-go
+// v1.0.0, which keeps doing so after the driver is stopped and uses what it receives
+func sdkReceiveLoop(ch <-chan *sdkModel.AsyncValues, received chan<- int, panicked chan<- interface{}) {
+	defer func() {
+		if r := recover(); r != nil {
+			panicked <- r
+		}
+	}()
+	for {
+		acv := <-ch
+		received <- len(acv.CommandValues)
+	}
+}
+
+func TestStop_KeepsAsyncChOpen(t *testing.T) {
+	w := expect.WrapT(t)
+	asyncCh := make(chan *sdkModel.AsyncValues, 1)
+	d := &Driver{
+		Logger:  logger.NewClient("test", false, "", "DEBUG"),
+		Config:  &configuration{ShutdownWaitSeconds: 1},
+		AsyncCh: asyncCh,
+		done:    make(chan interface{}),
+		abort:   make(chan interface{}),
+		stopped: make(chan interface{}),
+	}
+	// nothing to wait for
+	close(d.stopped)
+
+	received := make(chan int, 1)
+	panicked := make(chan interface{}, 1)
+	go sdkReceiveLoop(asyncCh, received, panicked)
+
+	asyncCh <- testReadings(1)
+	w.ShouldBeEqual(<-received, 2)
+
+	w.ShouldSucceed(d.Stop(false))
+	select {
+	case r := <-panicked:
+		t.Fatalf("the SDK's receive loop panicked: %v", r)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// the SDK still receives readings
+	asyncCh <- testReadings(2)
+	w.ShouldBeEqual(<-received, 2)
+}
+
+func TestWatchdog_RestartDoesNotLeak(t *testing.T) {
+	w := expect.WrapT(t)
+	d := &Driver{
+		Logger: logger.NewClient("test", false, "", "DEBUG"),
+		Config: &configuration{MaxReconnectWaitSeconds: 600},
+	}
+	d.setupWatchdog()
+	before := runtime.NumGoroutine()
+
+	// as on every failover and failback
+	for i := 0; i < 10; i++ {
+		d.startWatchdog()
+	}
+	w.As("one status loop").ShouldBeEqual(goroutinesDownTo(before+1), before+1)
+
+	d.stopWatchdog()
+	w.As("stopped").ShouldBeEqual(goroutinesDownTo(before), before)
+	w.ShouldBeNil(d.watchdogStatus)
+}
+
+// goroutinesDownTo waits up to a second for the number of goroutines to drop
+// to n, and returns the number of goroutines
+func goroutinesDownTo(n int) int {
+	for i := 0; i < 100 && runtime.NumGoroutine() > n; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	return runtime.NumGoroutine()
+}
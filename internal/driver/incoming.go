@@ -11,14 +11,15 @@ import (
 	"github.com/intel/rsp-sw-toolkit-im-suite-mqtt-device-service/internal/jsonrpc"
 	"time"
 
-	"github.com/eclipse/paho.mqtt.golang"
 	sdkModel "github.com/edgexfoundry/device-sdk-go/pkg/models"
 )
 
 const (
 	sensorHeartbeat        = "heartbeat"
 	inventoryEvent         = "inventory_data"
+	sensorStatusUpdate     = "status_update"
 	controllerStatusUpdate = "rsp_controller_status_update"
+	controllerHeartbeat    = "controller_heartbeat"
 
 	deviceIdKey  = "device_id"
 	tagDataKey   = "epc"
@@ -29,7 +30,15 @@ const (
 	controllerReady = "controller_ready"
 )
 
-func (driver *Driver) onIncomingDataReceived(message mqtt.Message) {
+// sensorReadingMethods are the notifications produced by a single sensor, which
+// are sent under the sensor's device if SensorDeviceReadings is enabled
+var sensorReadingMethods = map[string]bool{
+	sensorHeartbeat:    true,
+	inventoryEvent:     true,
+	sensorStatusUpdate: true,
+}
+
+func (driver *Driver) onIncomingDataReceived(message controllerMessage) {
 	outgoing := message.Payload()
 
 	var incomingData jsonrpc.Notification
@@ -64,7 +73,7 @@ func (driver *Driver) onIncomingDataReceived(message mqtt.Message) {
 		return
 	}
 
-	modified, err := driver.processResource(incomingData)
+	modified, err := driver.processResource(message.controller, incomingData)
 	if err != nil {
 		driver.Logger.Error("Incoming resource processing failed",
 			"resourceName", resourceName, "cause", err.Error())
@@ -74,21 +83,95 @@ func (driver *Driver) onIncomingDataReceived(message mqtt.Message) {
 		outgoing = modified
 	}
 
+	driver.sendNotification(message.controller, incomingData, outgoing, message.Topic())
+}
+
+// sendNotification sends the readings of a notification of the controller to EdgeX;
+// outgoing is the notification as it's sent, and topic is the one it arrived on
+func (driver *Driver) sendNotification(controller *rspController, incomingData jsonrpc.Notification, outgoing []byte, topic string) {
+	resourceName := incomingData.Method
+	deviceName, profileName := driver.readingDevice(controller, incomingData)
+
 	origin := time.Now().UnixNano() / int64(time.Millisecond)
-	value := sdkModel.NewStringValue(resourceName, origin, string(outgoing))
+	values := []*sdkModel.CommandValue{sdkModel.NewStringValue(resourceName, origin, string(outgoing))}
+
+	var err error
+	if resourceName == inventoryEvent && driver.Config.InventoryTagReadings {
+		values, err = inventoryTagValues(driver.Logger, outgoing)
+		if err != nil {
+			driver.Logger.Error("Inventory tag readings failed",
+				"resourceName", resourceName, "cause", err.Error())
+			return
+		}
+	}
+
+	// typed readings declared by the device profile for this notification
+	extracted, err := extractValues(driver.Logger, outgoing, origin, driver.notificationValues[profileName][resourceName])
+	if err != nil {
+		driver.Logger.Warn("Typed readings extraction failed",
+			"resourceName", resourceName, "profile", profileName, "cause", err.Error())
+	}
+	values = append(values, extracted...)
+
+	if len(values) == 0 {
+		driver.Logger.Debug("[Incoming listener] Incoming reading without values ignored",
+			"method", incomingData.Method)
+		return
+	}
 
 	driver.Logger.Info("[Incoming listener] Incoming reading received",
-		"topic", message.Topic(),
+		"topic", topic,
+		"device", deviceName,
 		"method", incomingData.Method,
-		"msgLen", len(message.Payload()))
+		"msgLen", len(outgoing),
+		"readings", len(values))
+
+	driver.sendReadings(&sdkModel.AsyncValues{
+		DeviceName:    deviceName,
+		CommandValues: values,
+	})
+}
+
+// sendReadings sends the readings to EdgeX. If the reading buffer is enabled,
+// readings are stored on disk to be sent later while core-data is unreachable
+// or EdgeX isn't ready to accept them.
+func (driver *Driver) sendReadings(av *sdkModel.AsyncValues) {
+	if driver.readingBuffer != nil {
+		err := driver.readingBuffer.send(driver.AsyncCh, av)
+		if err == nil {
+			return
+		}
+		// don't lose the readings; wait for EdgeX instead
+		driver.Logger.Error("Unable to buffer readings", "device", av.DeviceName, "cause", err.Error())
+	}
+
+	select {
+	case driver.AsyncCh <- av:
+	case <-driver.abort:
+		driver.Logger.Warn("Shutdown deadline passed; dropping readings",
+			"device", av.DeviceName, "readings", len(av.CommandValues))
+	}
+}
+
+// readingDevice returns the name and profile of the EdgeX device that readings
+// of the notification are sent under: the sensor that produced the data if it's
+// registered and SensorDeviceReadings is enabled, otherwise the controller
+func (driver *Driver) readingDevice(controller *rspController, data jsonrpc.Notification) (deviceName string, profileName string) {
+	if !driver.Config.SensorDeviceReadings || !sensorReadingMethods[data.Method] {
+		return controller.DeviceName, rspControllerDeviceProfile
+	}
 
-	driver.AsyncCh <- &sdkModel.AsyncValues{
-		DeviceName:    driver.Config.ControllerName,
-		CommandValues: []*sdkModel.CommandValue{value},
+	var deviceId string
+	if err := data.GetParam(deviceIdKey, &deviceId); err != nil {
+		return controller.DeviceName, rspControllerDeviceProfile
+	}
+	if sensorName, ok := driver.sensorDevices.Load(deviceId); ok {
+		return sensorName.(string), rspDeviceProfile
 	}
+	return controller.DeviceName, rspControllerDeviceProfile
 }
 
-func (driver *Driver) processResource(data jsonrpc.Notification) (modified []byte, err error) {
+func (driver *Driver) processResource(controller *rspController, data jsonrpc.Notification) (modified []byte, err error) {
 	switch data.Method {
 	case sensorHeartbeat:
 		// Register new (i.e., currently unregistered) sensors with EdgeX
@@ -97,7 +180,8 @@ func (driver *Driver) processResource(data jsonrpc.Notification) (modified []byt
 		if err != nil {
 			return
 		}
-		driver.registerDeviceIfNeeded(deviceId, rspDeviceProfile)
+		driver.registerDeviceIfNeeded(deviceId, rspDeviceProfile, controller.Id)
+		driver.onSensorHeartbeat(controller, deviceId)
 
 	case inventoryEvent:
 		var inventoryData []jsonrpc.Parameters
@@ -105,21 +189,13 @@ func (driver *Driver) processResource(data jsonrpc.Notification) (modified []byt
 		if err != nil {
 			return
 		}
+		// the device_id is only used to count decoding failures
+		var deviceId string
+		_ = data.GetParam(deviceIdKey, &deviceId)
 
-		for i := 0; i < len(inventoryData); i++ {
-			var tagData, URI string
-			err = inventoryData[i].Get(tagDataKey, &tagData)
-			if err != nil {
-				return
-			}
-			URI, err = driver.DecoderRing.TagDataToURI(tagData)
-			if err != nil {
-				return
-			}
-			err = inventoryData[i].Set(uriDataKey, URI)
-			if err != nil {
-				return
-			}
+		inventoryData, err = driver.decodeTagReads(deviceId, inventoryData)
+		if err != nil {
+			return
 		}
 
 		err = data.SetParam(paramDataKey, inventoryData)
@@ -128,6 +204,9 @@ func (driver *Driver) processResource(data jsonrpc.Notification) (modified []byt
 		}
 		modified, err = json.Marshal(data) // update the outgoing payload
 
+	case controllerHeartbeat:
+		driver.onControllerHeartbeat(controller)
+
 	case controllerStatusUpdate:
 		var status string
 		err = data.GetParam(statusKey, &status)
@@ -137,9 +216,60 @@ func (driver *Driver) processResource(data jsonrpc.Notification) (modified []byt
 
 		if status == controllerReady {
 			// tell the RSP controller which notifications we want to subscribe to
-			go driver.configureControllerNotifications()
+			go driver.configureControllerNotifications(controller)
 		}
 	}
 
 	return
 }
+
+// decodeTagReads adds the URI and TagRepresentations of the tag data to each of the
+// tag reads of the sensor; reads whose tag data can't be decoded are handled as the
+// TagDecodeFailurePolicy says
+func (driver *Driver) decodeTagReads(deviceId string, reads []jsonrpc.Parameters) ([]jsonrpc.Parameters, error) {
+	decoded := reads[:0]
+	var failures int
+	var lastFailure error
+	for _, read := range reads {
+		var tagData string
+		if err := read.Get(tagDataKey, &tagData); err != nil {
+			return nil, err
+		}
+
+		var URI interface{}
+		tag, _, err := driver.DecoderRing.Decode(tagData)
+		if err == nil {
+			URI = tag.URI
+		} else {
+			failures++
+			lastFailure = err
+			driver.tagDecodeFailures.add(deviceId)
+			switch driver.Config.TagDecodeFailurePolicy {
+			case dropPolicy:
+				continue
+			case rawURIPolicy:
+				URI = rawTagURI(tagData)
+			}
+		}
+
+		if err := read.Set(uriDataKey, URI); err != nil {
+			return nil, err
+		}
+		for _, name := range driver.Config.TagRepresentations {
+			value := tag.representation(name, driver.Config.TagDigitalLinkDomain)
+			if value == "" {
+				continue // not a GS1 EPC
+			}
+			if err := read.Set(name, value); err != nil {
+				return nil, err
+			}
+		}
+		decoded = append(decoded, read)
+	}
+
+	if failures > 0 {
+		driver.Logger.Warn("Unable to decode tag data", "deviceId", deviceId, "failures", failures,
+			"reads", len(reads), "policy", driver.Config.TagDecodeFailurePolicy, "cause", lastFailure.Error())
+	}
+	return decoded, nil
+}
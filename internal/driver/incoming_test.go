@@ -10,7 +10,6 @@ import (
 	"encoding/json"
 	"github.com/edgexfoundry/go-mod-core-contracts/clients/logger"
 	expect "github.com/intel/rsp-sw-toolkit-im-suite-expect"
-	gojsonschema "github.com/intel/rsp-sw-toolkit-im-suite-gojsonschema"
 	"github.com/intel/rsp-sw-toolkit-im-suite-mqtt-device-service/internal/jsonrpc"
 	"testing"
 )
@@ -20,7 +19,8 @@ func init() {
 	driverInstance.Logger = logger.NewClient("test", false, "", "DEBUG")
 
 	driverInstance.Config = &configuration{
-		TagFormats: []string{"sgtin"},
+		TagFormats:             []string{"sgtin"},
+		TagDecodeFailurePolicy: nullURIPolicy,
 	}
 	err := driverInstance.setupDecoderRing()
 	if err != nil {
@@ -39,7 +39,7 @@ func TestProcessTagData(t *testing.T) {
 		},
 	}
 
-	modified := w.ShouldHaveResult(driverInstance.processResource(n)).([]byte)
+	modified := w.ShouldHaveResult(driverInstance.processResource(&rspController{}, n)).([]byte)
 	w.ShouldNotBeNil(modified)
 
 	var result jsonrpc.Notification
@@ -103,7 +103,7 @@ func TestProcessTagData_fullMessage(t *testing.T) {
 	var n jsonrpc.Notification
 	w.ShouldSucceed(json.Unmarshal(jsonData, &n))
 
-	modified := w.ShouldHaveResult(driverInstance.processResource(n)).([]byte)
+	modified := w.ShouldHaveResult(driverInstance.processResource(&rspController{}, n)).([]byte)
 	w.ShouldNotBeNil(modified)
 
 	var result jsonrpc.Notification
@@ -124,12 +124,100 @@ func TestProcessTagData_fullMessage(t *testing.T) {
 		json.RawMessage(`"30143639F84191AD23901607"`))
 }
 
+func TestProcessTagData_representations(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	d := &Driver{
+		Logger: logger.NewClient("test", false, "", "DEBUG"),
+		Config: &configuration{
+			TagFormats:             []string{"sgtin", "bittag"},
+			TagBitBoundary:         []int{8, 48, 40},
+			TagURIAuthorityName:    "test.com",
+			TagURIAuthorityDate:    "2019-01-01",
+			TagRepresentations:     []string{tagURIRepresentation, elementStringRepresentation, digitalLinkRepresentation},
+			TagDigitalLinkDomain:   "https://id.gs1.org",
+			TagDecodeFailurePolicy: nullURIPolicy,
+		},
+	}
+	w.ShouldSucceed(d.setupDecoderRing())
+
+	n := jsonrpc.Notification{
+		Version: jsonrpc.Version,
+		Method:  inventoryEvent,
+		Params: map[string]json.RawMessage{
+			paramDataKey: []byte(`[{"epc":"30143639F84191AD22901607"},{"epc":"0F00000000000C00000014D2"}]`),
+		},
+	}
+	modified := w.ShouldHaveResult(d.processResource(&rspController{}, n)).([]byte)
+
+	var result jsonrpc.Notification
+	var data []map[string]string
+	w.ShouldSucceed(json.Unmarshal(modified, &result))
+	w.ShouldSucceed(json.Unmarshal(result.Params[paramDataKey], &data))
+	w.ShouldHaveLength(data, 2)
+	w.ShouldBeEqual(data[0], map[string]string{
+		tagDataKey:                  "30143639F84191AD22901607",
+		uriDataKey:                  "urn:epc:id:sgtin:0888446.067142.193853396487",
+		tagURIRepresentation:        "urn:epc:tag:sgtin-96:0.0888446.067142.193853396487",
+		elementStringRepresentation: "(01)00888446671424(21)193853396487",
+		digitalLinkRepresentation:   "https://id.gs1.org/01/00888446671424/21/193853396487",
+	})
+	w.As("not a GS1 EPC").ShouldBeEqual(data[1], map[string]string{
+		tagDataKey: "0F00000000000C00000014D2",
+		uriDataKey: "tag:test.com,2019-01-01:15.12.5330",
+	})
+
+	d.Config.TagRepresentations = []string{"gtin"}
+	w.As("unknown representation").ShouldFail(d.setupDecoderRing())
+}
+
+func TestDecodeTagReads(t *testing.T) {
+	w := expect.WrapT(t)
+	d := &Driver{
+		Logger: logger.NewClient("test", false, "", "DEBUG"),
+		Config: &configuration{TagFormats: []string{"sgtin"}, TagDecodeFailurePolicy: nullURIPolicy},
+	}
+	w.StopOnMismatch().ShouldSucceed(d.setupDecoderRing())
+
+	decode := func(policy string) []map[string]interface{} {
+		d.Config.TagDecodeFailurePolicy = policy
+		var reads []jsonrpc.Parameters
+		w.StopOnMismatch().ShouldSucceed(json.Unmarshal([]byte(`[
+			{"epc":"30143639F84191AD22901607"},
+			{"epc":"0F00000000000C00000014D2"},
+			{"epc":"30143639F84191AD23901607"}]`), &reads))
+		reads = w.StopOnMismatch().ShouldHaveResult(d.decodeTagReads("RSP-15077a", reads)).([]jsonrpc.Parameters)
+
+		var result []map[string]interface{}
+		w.StopOnMismatch().ShouldSucceed(json.Unmarshal(
+			w.ShouldHaveResult(json.Marshal(reads)).([]byte), &result))
+		return result
+	}
+
+	reads := decode(nullURIPolicy)
+	w.StopOnMismatch().ShouldHaveLength(reads, 3)
+	w.ShouldBeEqual(reads[0][uriDataKey], "urn:epc:id:sgtin:0888446.067142.193853396487")
+	w.As("null").ShouldBeNil(reads[1][uriDataKey])
+	w.ShouldContain(reads[1], []string{uriDataKey})
+	w.ShouldBeEqual(reads[2][uriDataKey], "urn:epc:id:sgtin:0888446.067142.193870173703")
+
+	reads = decode(rawURIPolicy)
+	w.StopOnMismatch().ShouldHaveLength(reads, 3)
+	w.As("raw").ShouldBeEqual(reads[1][uriDataKey], "urn:epc:raw:96.x0F00000000000C00000014D2")
+
+	reads = decode(dropPolicy)
+	w.StopOnMismatch().ShouldHaveLength(reads, 2)
+	w.As("drop").ShouldBeEqual(reads[1][tagDataKey], "30143639F84191AD23901607")
+
+	status := d.status()
+	w.ShouldBeEqual(status.TagDecodeFailures.Devices, map[string]uint64{"RSP-15077a": 3})
+	w.ShouldBeEqual(status.TagDecodeFailures.Decoders, map[string]uint64{noDecoderName: 3})
+	w.ShouldBeEqual(status.TagDecoders, map[string]uint64{"SGTIN": 6})
+}
+
 func TestJSONValidation(t *testing.T) {
 	w := expect.WrapT(t)
 	d := &Driver{
-		incomingSchemas: map[string]*gojsonschema.Schema{},
-		responseSchemas: map[string]*gojsonschema.Schema{},
-		Config:          &configuration{SchemasDir: "testdata"},
+		Config: &configuration{SchemasDir: "testdata"},
 	}
 
 	w.As("empty method").ShouldHaveError(d.loadSchema(incomingDir, ""))
@@ -165,3 +253,33 @@ func TestJSONValidation(t *testing.T) {
 	w.As("no such method").ShouldFail(d.validateResponse("no_such_method", []byte{0x00}))
 	w.As("bad schema").ShouldFail(d.validateResponse("invalid", []byte{0x00}))
 }
+
+func TestReadingDevice(t *testing.T) {
+	w := expect.WrapT(t)
+	d := &Driver{Config: &configuration{SensorDeviceReadings: true}}
+	controller := &rspController{DeviceName: "rsp-controller"}
+	d.rememberSensor("RSP-15077a", "renamed-sensor", rspDeviceProfile)
+	d.rememberSensor("rsp-controller", "rsp-controller", rspControllerDeviceProfile)
+
+	check := func(name, expectedDevice, expectedProfile string, n jsonrpc.Notification) {
+		deviceName, profileName := d.readingDevice(controller, n)
+		w.As(name).ShouldBeEqual(deviceName, expectedDevice)
+		w.As(name).ShouldBeEqual(profileName, expectedProfile)
+	}
+
+	n := jsonrpc.Notification{Version: jsonrpc.Version, Method: inventoryEvent}
+	check("no device_id", "rsp-controller", rspControllerDeviceProfile, n)
+
+	w.ShouldSucceed(n.SetParam(deviceIdKey, "RSP-15077b"))
+	check("unregistered sensor", "rsp-controller", rspControllerDeviceProfile, n)
+
+	w.ShouldSucceed(n.SetParam(deviceIdKey, "RSP-15077a"))
+	check("registered sensor", "renamed-sensor", rspDeviceProfile, n)
+
+	n.Method = "sensor_config_notification"
+	check("controller notification", "rsp-controller", rspControllerDeviceProfile, n)
+
+	n.Method = inventoryEvent
+	d.Config.SensorDeviceReadings = false
+	check("disabled", "rsp-controller", rspControllerDeviceProfile, n)
+}
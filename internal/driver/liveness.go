@@ -0,0 +1,265 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package driver
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/intel/rsp-sw-toolkit-im-suite-mqtt-device-service/internal/jsonrpc"
+	"github.com/pkg/errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	deviceAlert = "device_alert"
+
+	// sensorSilentAlertNumber identifies the device_alert the driver raises for a silent sensor
+	sensorSilentAlertNumber = 199
+	sensorSilentSeverity    = "warning"
+)
+
+// heartbeatTracker tracks the heartbeats of devices by key. A device is silent once
+// it misses too many heartbeats, until its heartbeats resume. Devices are tracked
+// from their first heartbeat on.
+type heartbeatTracker struct {
+	mutex   sync.Mutex
+	devices map[string]*heartbeatState
+}
+
+// heartbeatState is the heartbeat state of a single device
+type heartbeatState struct {
+	controller *rspController
+	last       time.Time
+	silent     bool
+}
+
+// silentDevice is a device which has just become silent
+type silentDevice struct {
+	key           string
+	controller    *rspController
+	lastHeartbeat time.Time
+}
+
+// validateLiveness checks the heartbeat settings of the config
+func (config *configuration) validateLiveness() error {
+	if err := validateHeartbeats(SensorHeartbeatPeriodSeconds, config.SensorHeartbeatPeriodSeconds,
+		SensorMissedHeartbeats, config.SensorMissedHeartbeats); err != nil {
+		return err
+	}
+	return validateHeartbeats(ControllerHeartbeatPeriodSeconds, config.ControllerHeartbeatPeriodSeconds,
+		ControllerMissedHeartbeats, config.ControllerMissedHeartbeats)
+}
+
+func validateHeartbeats(periodKey string, period int, missedKey string, missed int) error {
+	if missed < 0 {
+		return errors.Errorf("%s must not be negative", missedKey)
+	}
+	if missed > 0 && period <= 0 {
+		return errors.Errorf("%s must be positive to track heartbeats", periodKey)
+	}
+	return nil
+}
+
+// heartbeat records a heartbeat of the device of the controller at the given
+// time, and returns true if the device was silent until now
+func (h *heartbeatTracker) heartbeat(key string, controller *rspController, at time.Time) (resumed bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.devices == nil {
+		h.devices = map[string]*heartbeatState{}
+	}
+	device, ok := h.devices[key]
+	if !ok {
+		device = &heartbeatState{}
+		h.devices[key] = device
+	}
+	resumed = device.silent
+	device.controller = controller
+	device.last = at
+	device.silent = false
+	return resumed
+}
+
+// silence marks the devices whose last heartbeat is before the deadline as silent,
+// and returns the ones which weren't silent yet, ordered by key
+func (h *heartbeatTracker) silence(deadline time.Time) []silentDevice {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	var silenced []silentDevice
+	for key, device := range h.devices {
+		if device.silent || !device.last.Before(deadline) {
+			continue
+		}
+		device.silent = true
+		silenced = append(silenced, silentDevice{key: key, controller: device.controller, lastHeartbeat: device.last})
+	}
+	sort.Slice(silenced, func(i, j int) bool { return silenced[i].key < silenced[j].key })
+	return silenced
+}
+
+// isSilent returns true if the device is silent
+func (h *heartbeatTracker) isSilent(key string) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	device, ok := h.devices[key]
+	return ok && device.silent
+}
+
+// restart gives every device which isn't silent until a full timeout from now to
+// send its next heartbeat; heartbeats don't arrive while the connection is down
+func (h *heartbeatTracker) restart(at time.Time) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for _, device := range h.devices {
+		if !device.silent {
+			device.last = at
+		}
+	}
+}
+
+// watchHeartbeats checks the tracker for devices which missed heartbeats once per
+// heartbeat period until done is signaled, and calls onSilent with the ones which
+// just became silent. It doesn't while the driver isn't connected.
+func (driver *Driver) watchHeartbeats(tracker *heartbeatTracker, periodSeconds, missed int, onSilent func([]silentDevice)) {
+	period := time.Duration(periodSeconds) * time.Second
+	timeout := period * time.Duration(missed)
+
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-driver.done:
+			return
+		}
+		if !driver.isConnected() {
+			continue
+		}
+		if silenced := tracker.silence(time.Now().Add(-timeout)); len(silenced) > 0 {
+			onSilent(silenced)
+		}
+	}
+}
+
+// watchLiveness watches the heartbeats of the sensors and controllers, if enabled
+func (driver *Driver) watchLiveness() {
+	config := driver.Config
+	if config.SensorMissedHeartbeats > 0 {
+		driver.senders.Add(1)
+		go func() {
+			defer driver.senders.Done()
+			driver.watchHeartbeats(&driver.sensorHeartbeats, config.SensorHeartbeatPeriodSeconds,
+				config.SensorMissedHeartbeats, driver.onSilentSensors)
+		}()
+	}
+	if config.ControllerMissedHeartbeats > 0 {
+		go driver.watchHeartbeats(&driver.controllerHeartbeats, config.ControllerHeartbeatPeriodSeconds,
+			config.ControllerMissedHeartbeats, driver.onSilentControllers)
+	}
+}
+
+// restartLiveness gives every device a full timeout to send its next heartbeat
+func (driver *Driver) restartLiveness() {
+	now := time.Now()
+	driver.sensorHeartbeats.restart(now)
+	driver.controllerHeartbeats.restart(now)
+}
+
+// onSensorHeartbeat tracks the liveness of the sensor sending a heartbeat
+func (driver *Driver) onSensorHeartbeat(controller *rspController, deviceId string) {
+	if driver.Config.SensorMissedHeartbeats == 0 {
+		return
+	}
+	if driver.sensorHeartbeats.heartbeat(deviceId, controller, time.Now()) {
+		driver.Logger.Info("Sensor heartbeats resumed", "deviceId", deviceId, "controller", controller.DeviceName)
+		go driver.updateOperatingStates()
+	}
+}
+
+// onControllerHeartbeat tracks the liveness of the controller. When its heartbeats
+// resume, it's asked again for the notifications, which it may have lost.
+func (driver *Driver) onControllerHeartbeat(controller *rspController) {
+	if driver.Config.ControllerMissedHeartbeats == 0 {
+		return
+	}
+	if driver.controllerHeartbeats.heartbeat(controller.DeviceName, controller, time.Now()) {
+		driver.Logger.Info("RSP Controller heartbeats resumed", "controller", controller.DeviceName)
+		go driver.updateOperatingStates()
+		go driver.configureControllerNotifications(controller)
+	}
+}
+
+// isControllerSilent returns true if the controller stopped sending heartbeats
+func (driver *Driver) isControllerSilent(controller *rspController) bool {
+	return driver.controllerHeartbeats.isSilent(controller.DeviceName)
+}
+
+// onSilentSensors disables the sensors and sends an alert for each of them
+func (driver *Driver) onSilentSensors(sensors []silentDevice) {
+	for _, sensor := range sensors {
+		driver.Logger.Warn("Sensor stopped sending heartbeats", "deviceId", sensor.key,
+			"controller", sensor.controller.DeviceName, "lastHeartbeat", sensor.lastHeartbeat.Format(time.RFC3339))
+		if err := driver.sendSensorSilentAlert(sensor, driver.Config.SensorMissedHeartbeats); err != nil {
+			driver.Logger.Error("Unable to send the silent sensor alert", "deviceId", sensor.key, "cause", err.Error())
+		}
+	}
+	driver.updateOperatingStates()
+}
+
+// onSilentControllers disables the controllers and fails the commands waiting for
+// their responses, which won't come
+func (driver *Driver) onSilentControllers(controllers []silentDevice) {
+	for _, silent := range controllers {
+		driver.Logger.Warn("RSP Controller stopped sending heartbeats", "controller", silent.controller.DeviceName,
+			"lastHeartbeat", silent.lastHeartbeat.Format(time.RFC3339))
+		driver.failCommands(silent.controller, errors.Errorf(
+			"RSP Controller %s stopped sending heartbeats", silent.controller.DeviceName))
+	}
+	driver.updateOperatingStates()
+}
+
+// sendSensorSilentAlert sends a device_alert reading, like the ones of the RSP Controller,
+// telling that the sensor missed the given number of heartbeats
+func (driver *Driver) sendSensorSilentAlert(sensor silentDevice, missed int) error {
+	params := map[string]interface{}{
+		"sent_on":           time.Now().UnixNano() / int64(time.Millisecond),
+		"device_id":         sensor.key,
+		"controller_id":     nil,
+		"facilities":        []string{},
+		"alert_number":      sensorSilentAlertNumber,
+		"alert_description": fmt.Sprintf("Sensor missed %d heartbeats", missed),
+		"severity":          sensorSilentSeverity,
+		"optional": map[string]interface{}{
+			"last_heartbeat":    sensor.lastHeartbeat.UnixNano() / int64(time.Millisecond),
+			"missed_heartbeats": missed,
+		},
+	}
+	if sensor.controller.Id != "" {
+		params["controller_id"] = sensor.controller.Id
+	}
+
+	alert := jsonrpc.Notification{Version: jsonRpcVersion, Method: deviceAlert, Params: jsonrpc.Parameters{}}
+	for key, value := range params {
+		if err := alert.SetParam(key, value); err != nil {
+			return err
+		}
+	}
+	outgoing, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	if err := driver.validateIncoming(deviceAlert, outgoing); err != nil {
+		return err
+	}
+
+	driver.sendNotification(sensor.controller, alert, outgoing, "")
+	return nil
+}
@@ -0,0 +1,90 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package driver
+
+import (
+	"encoding/json"
+	sdkModel "github.com/edgexfoundry/device-sdk-go/pkg/models"
+	"github.com/edgexfoundry/go-mod-core-contracts/clients/logger"
+	expect "github.com/intel/rsp-sw-toolkit-im-suite-expect"
+	"github.com/intel/rsp-sw-toolkit-im-suite-mqtt-device-service/internal/jsonrpc"
+	"testing"
+	"time"
+)
+
+func TestValidateLiveness(t *testing.T) {
+	w := expect.WrapT(t)
+	w.ShouldSucceed((&configuration{}).validateLiveness())
+	w.ShouldSucceed((&configuration{SensorHeartbeatPeriodSeconds: 30, SensorMissedHeartbeats: 3}).validateLiveness())
+	w.ShouldFail((&configuration{SensorMissedHeartbeats: 3}).validateLiveness())
+	w.ShouldFail((&configuration{SensorHeartbeatPeriodSeconds: 30, SensorMissedHeartbeats: -1}).validateLiveness())
+	w.ShouldSucceed((&configuration{ControllerHeartbeatPeriodSeconds: 30, ControllerMissedHeartbeats: 3}).validateLiveness())
+	w.ShouldFail((&configuration{ControllerMissedHeartbeats: 3}).validateLiveness())
+}
+
+func TestHeartbeatTracker(t *testing.T) {
+	w := expect.WrapT(t)
+	controller := &rspController{DeviceName: "rsp-controller"}
+	start := time.Now()
+	l := &heartbeatTracker{}
+
+	w.ShouldBeFalse(l.isSilent("RSP-150000"))
+	w.ShouldBeFalse(l.heartbeat("RSP-150000", controller, start))
+	w.ShouldBeFalse(l.heartbeat("RSP-150001", controller, start.Add(time.Minute)))
+
+	w.ShouldBeEqual(len(l.silence(start)), 0)
+	silenced := l.silence(start.Add(time.Second))
+	w.ShouldBeEqual(len(silenced), 1)
+	w.ShouldBeEqual(silenced[0].key, "RSP-150000")
+	w.ShouldBeEqual(silenced[0].controller, controller)
+	w.ShouldBeEqual(silenced[0].lastHeartbeat, start)
+	w.ShouldBeTrue(l.isSilent("RSP-150000"))
+	w.ShouldBeFalse(l.isSilent("RSP-150001"))
+
+	// a silent device is only reported once
+	w.ShouldBeEqual(len(l.silence(start.Add(time.Second))), 0)
+
+	// after re-connecting, devices get a full timeout again
+	l.restart(start.Add(2 * time.Minute))
+	w.ShouldBeEqual(len(l.silence(start.Add(90*time.Second))), 0)
+	w.ShouldBeTrue(l.isSilent("RSP-150000"))
+
+	w.ShouldBeTrue(l.heartbeat("RSP-150000", controller, start.Add(3*time.Minute)))
+	w.ShouldBeFalse(l.isSilent("RSP-150000"))
+}
+
+func TestSendSensorSilentAlert(t *testing.T) {
+	w := expect.WrapT(t)
+	asyncCh := make(chan *sdkModel.AsyncValues, 1)
+	d := &Driver{
+		Logger:  logger.NewClient("test", false, "", "DEBUG"),
+		Config:  &configuration{SchemasDir: "../../cmd/res/schemas"},
+		AsyncCh: asyncCh,
+	}
+	controller := &rspController{Id: "rsp-1", DeviceName: "rsp-controller-1"}
+	lastHeartbeat := time.Unix(1500000000, 0)
+
+	w.ShouldSucceed(d.sendSensorSilentAlert(silentDevice{
+		key:           "RSP-150000",
+		controller:    controller,
+		lastHeartbeat: lastHeartbeat,
+	}, 3))
+
+	av := <-asyncCh
+	w.ShouldBeEqual(av.DeviceName, "rsp-controller-1")
+	w.ShouldBeEqual(len(av.CommandValues), 1)
+	w.ShouldBeEqual(av.CommandValues[0].DeviceResourceName, deviceAlert)
+
+	var alert jsonrpc.Notification
+	w.ShouldSucceed(json.Unmarshal([]byte(av.CommandValues[0].ValueToString()), &alert))
+	w.ShouldBeEqual(alert.Method, deviceAlert)
+	w.ShouldBeEqual(alert.Params[deviceIdKey], json.RawMessage(`"RSP-150000"`))
+	w.ShouldBeEqual(alert.Params["controller_id"], json.RawMessage(`"rsp-1"`))
+	w.ShouldBeEqual(alert.Params["alert_number"], json.RawMessage(`199`))
+	w.ShouldBeEqual(alert.Params["optional"],
+		json.RawMessage(`{"last_heartbeat":1500000000000,"missed_heartbeats":3}`))
+}
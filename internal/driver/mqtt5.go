@@ -0,0 +1,328 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package driver
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/eclipse/paho.golang/paho"
+	"github.com/eclipse/paho.mqtt.golang"
+	"github.com/pkg/errors"
+	"math"
+	"net"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	mqttVersion311 = "3.1.1"
+	mqttVersion5   = "5"
+
+	// mqtt5Timeout limits dialing the broker and waiting for it to acknowledge a packet
+	mqtt5Timeout = 30 * time.Second
+)
+
+// brokerClient is the part of an mqtt client used by the driver. It's implemented
+// by the paho client for MQTT 3.1.1 and by mqtt5Client for MQTT 5.
+type brokerClient interface {
+	IsConnected() bool
+	Connect() mqtt.Token
+	Disconnect(quiesce uint)
+	Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token
+	Subscribe(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token
+	Unsubscribe(topics ...string) mqtt.Token
+	AddRoute(topic string, callback mqtt.MessageHandler)
+}
+
+// usesMqtt5 returns true if the MqttProtocolVersion is MQTT 5
+func (config *configuration) usesMqtt5() (bool, error) {
+	switch config.MqttProtocolVersion {
+	case mqttVersion311:
+		return false, nil
+	case mqttVersion5:
+		return true, nil
+	}
+	return false, errors.Errorf("unsupported %s %q; use %s or %s",
+		MqttProtocolVersion, config.MqttProtocolVersion, mqttVersion311, mqttVersion5)
+}
+
+// mqtt5Client is an MQTT 5 client for a single connection to a broker; it
+// doesn't reconnect, so the driver creates a new one for every connection.
+// Message handlers are called with a nil mqtt.Client.
+type mqtt5Client struct {
+	server           *url.URL
+	tlsConfig        *tls.Config
+	websocket        *websocketDialer
+	connect          *paho.Connect
+	onConnect        func(brokerClient)
+	onConnectionLost func(brokerClient, error)
+
+	router *paho.StandardRouter
+	// routes are the topic filters with a handler in the router
+	routes     map[string]bool
+	routeMutex sync.Mutex
+
+	// client is set by Connect before connected is
+	client *paho.Client
+	// connected is 1 while the connection is up, accessed atomically
+	connected int32
+}
+
+// newMqtt5Client creates an MQTT 5 client for the server based on the driver config;
+// WebSocket brokers are reached with the dialer
+func (driver *Driver) newMqtt5Client(server *url.URL, dialer *websocketDialer) *mqtt5Client {
+	config := driver.Config
+	connect := &paho.Connect{
+		ClientID:     config.MqttClientId,
+		KeepAlive:    uint16(config.MqttKeepAlive),
+		CleanStart:   config.MqttCleanSession,
+		Username:     config.MqttUser,
+		UsernameFlag: config.MqttUser != "",
+		Password:     []byte(config.MqttPassword),
+		PasswordFlag: config.MqttPassword != "",
+	}
+	if !config.MqttCleanSession {
+		// like an MQTT 3.1.1 persistent session, it never expires
+		expiry := uint32(math.MaxUint32)
+		connect.Properties = &paho.ConnectProperties{SessionExpiryInterval: &expiry}
+	}
+
+	return &mqtt5Client{
+		server:           server,
+		tlsConfig:        driver.brokerTLSConfig(server),
+		websocket:        dialer,
+		connect:          connect,
+		onConnect:        driver.onMqttConnect,
+		onConnectionLost: driver.onMqttConnectionLost,
+		router:           paho.NewStandardRouter(),
+		routes:           map[string]bool{},
+	}
+}
+
+func (c *mqtt5Client) IsConnected() bool {
+	return atomic.LoadInt32(&c.connected) == 1
+}
+
+func (c *mqtt5Client) Connect() mqtt.Token {
+	return newMqtt5Token(func() error {
+		conn, err := c.dial()
+		if err != nil {
+			return err
+		}
+
+		c.client = paho.NewClient(paho.ClientConfig{
+			Conn:          conn,
+			Router:        c.router,
+			PacketTimeout: mqtt5Timeout,
+			OnClientError: c.lost,
+			OnServerDisconnect: func(d *paho.Disconnect) {
+				c.lost(errors.Errorf("disconnected by the broker with reason code %#x", d.ReasonCode))
+			},
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), mqtt5Timeout)
+		defer cancel()
+		if _, err := c.client.Connect(ctx, c.connect); err != nil {
+			return err
+		}
+
+		atomic.StoreInt32(&c.connected, 1)
+		go c.onConnect(c)
+		return nil
+	})
+}
+
+// dial opens the network connection to the server
+func (c *mqtt5Client) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: mqtt5Timeout}
+	switch c.server.Scheme {
+	case "ws", "wss":
+		return c.websocket.dial()
+	case "ssl", "tls", "tcps":
+		return tls.DialWithDialer(dialer, "tcp", c.server.Host, c.tlsConfig)
+	default:
+		return dialer.Dial("tcp", c.server.Host)
+	}
+}
+
+// lost handles the connection failing, unless it was closed already
+func (c *mqtt5Client) lost(err error) {
+	if atomic.CompareAndSwapInt32(&c.connected, 1, 0) {
+		c.onConnectionLost(c, err)
+	}
+}
+
+// Disconnect closes the connection right away; unlike paho's MQTT 3.1.1 client
+// there's no queue of outgoing work to wait for
+func (c *mqtt5Client) Disconnect(quiesce uint) {
+	if atomic.CompareAndSwapInt32(&c.connected, 1, 0) {
+		_ = c.client.Disconnect(&paho.Disconnect{ReasonCode: 0})
+	}
+}
+
+func (c *mqtt5Client) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	publish := &paho.Publish{Topic: topic, QoS: qos, Retain: retained}
+	switch p := payload.(type) {
+	case []byte:
+		publish.Payload = p
+	case string:
+		publish.Payload = []byte(p)
+	default:
+		return failedMqtt5Token(errors.Errorf("unsupported payload type %T", payload))
+	}
+	return c.publish(publish)
+}
+
+// publishRequest publishes a request along with the MQTT 5 properties which
+// tell the receiver where to send the response and how to correlate it
+func (c *mqtt5Client) publishRequest(topic string, qos byte, payload []byte, properties *paho.PublishProperties) mqtt.Token {
+	return c.publish(&paho.Publish{Topic: topic, QoS: qos, Payload: payload, Properties: properties})
+}
+
+func (c *mqtt5Client) publish(publish *paho.Publish) mqtt.Token {
+	if !c.IsConnected() {
+		return failedMqtt5Token(mqtt.ErrNotConnected)
+	}
+	return newMqtt5Token(func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), mqtt5Timeout)
+		defer cancel()
+		_, err := c.client.Publish(ctx, publish)
+		return err
+	})
+}
+
+func (c *mqtt5Client) Subscribe(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token {
+	if !c.IsConnected() {
+		return failedMqtt5Token(mqtt.ErrNotConnected)
+	}
+	return newMqtt5Token(func() error {
+		// added first so that no message is missed after the broker acknowledges
+		c.AddRoute(topic, callback)
+
+		ctx, cancel := context.WithTimeout(context.Background(), mqtt5Timeout)
+		defer cancel()
+		_, err := c.client.Subscribe(ctx, &paho.Subscribe{
+			Subscriptions: map[string]paho.SubscribeOptions{topic: {QoS: qos}},
+		})
+		return err
+	})
+}
+
+// AddRoute makes the callback handle messages matching the topic filter without
+// subscribing to it. Like paho's MQTT 3.1.1 client, there's one route per filter;
+// a filter which already has a route keeps it.
+func (c *mqtt5Client) AddRoute(topic string, callback mqtt.MessageHandler) {
+	// messages of shared subscriptions arrive on the topic without the group
+	filter := unshared(topic)
+
+	c.routeMutex.Lock()
+	defer c.routeMutex.Unlock()
+	if c.routes[filter] {
+		return
+	}
+	c.routes[filter] = true
+	c.router.RegisterHandler(filter, func(publish *paho.Publish) {
+		callback(nil, &mqtt5Message{publish: publish})
+	})
+}
+
+func (c *mqtt5Client) Unsubscribe(topics ...string) mqtt.Token {
+	if !c.IsConnected() {
+		return failedMqtt5Token(mqtt.ErrNotConnected)
+	}
+	return newMqtt5Token(func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), mqtt5Timeout)
+		defer cancel()
+		if _, err := c.client.Unsubscribe(ctx, &paho.Unsubscribe{Topics: topics}); err != nil {
+			return err
+		}
+		c.routeMutex.Lock()
+		defer c.routeMutex.Unlock()
+		for _, topic := range topics {
+			c.router.UnregisterHandler(unshared(topic))
+			delete(c.routes, unshared(topic))
+		}
+		return nil
+	})
+}
+
+// mqtt5Message is a received MQTT 5 publish as an mqtt.Message
+type mqtt5Message struct {
+	publish *paho.Publish
+}
+
+// Duplicate is always false; the flag isn't available from the MQTT 5 client
+func (m *mqtt5Message) Duplicate() bool   { return false }
+func (m *mqtt5Message) Qos() byte         { return m.publish.QoS }
+func (m *mqtt5Message) Retained() bool    { return m.publish.Retain }
+func (m *mqtt5Message) Topic() string     { return m.publish.Topic }
+func (m *mqtt5Message) MessageID() uint16 { return m.publish.PacketID }
+func (m *mqtt5Message) Payload() []byte   { return m.publish.Payload }
+
+// Ack does nothing; the MQTT 5 client acknowledges messages once they're handled
+func (m *mqtt5Message) Ack() {}
+
+// correlationData returns the MQTT 5 correlation data of the message, if it has any
+func correlationData(message mqtt.Message) []byte {
+	if cm, ok := message.(controllerMessage); ok {
+		message = cm.Message
+	}
+	if m, ok := message.(*mqtt5Message); ok && m.publish.Properties != nil {
+		return m.publish.Properties.CorrelationData
+	}
+	return nil
+}
+
+// mqtt5Token is the mqtt.Token of an operation of an mqtt5Client
+type mqtt5Token struct {
+	done chan struct{}
+	err  error
+}
+
+// newMqtt5Token runs the operation in the background and completes the token with its result
+func newMqtt5Token(operation func() error) *mqtt5Token {
+	token := &mqtt5Token{done: make(chan struct{})}
+	go func() {
+		token.err = operation()
+		close(token.done)
+	}()
+	return token
+}
+
+// failedMqtt5Token returns a token which has completed with the error
+func failedMqtt5Token(err error) *mqtt5Token {
+	token := &mqtt5Token{done: make(chan struct{}), err: err}
+	close(token.done)
+	return token
+}
+
+func (t *mqtt5Token) Wait() bool {
+	<-t.done
+	return true
+}
+
+func (t *mqtt5Token) WaitTimeout(timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-t.done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+func (t *mqtt5Token) Error() error {
+	select {
+	case <-t.done:
+		return t.err
+	default:
+		return nil
+	}
+}
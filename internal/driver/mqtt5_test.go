@@ -0,0 +1,208 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package driver
+
+import (
+	"github.com/eclipse/paho.golang/packets"
+	"github.com/eclipse/paho.golang/paho"
+	"github.com/eclipse/paho.mqtt.golang"
+	"github.com/edgexfoundry/go-mod-core-contracts/clients/logger"
+	expect "github.com/intel/rsp-sw-toolkit-im-suite-expect"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestUsesMqtt5(t *testing.T) {
+	w := expect.WrapT(t)
+	w.ShouldBeFalse(w.ShouldHaveResult((&configuration{MqttProtocolVersion: "3.1.1"}).usesMqtt5()).(bool))
+	w.ShouldBeTrue(w.ShouldHaveResult((&configuration{MqttProtocolVersion: "5"}).usesMqtt5()).(bool))
+	w.ShouldHaveError((&configuration{MqttProtocolVersion: "3.1"}).usesMqtt5())
+}
+
+// testBroker is a minimal MQTT 5 broker for a single client; it acknowledges
+// everything, answers a subscription with a response, and reports what's published
+type testBroker struct {
+	listener  net.Listener
+	conn      chan net.Conn
+	connects  chan *packets.Connect
+	published chan *packets.Publish
+	// queued is sent right after the connection is acknowledged,
+	// like the messages of a resumed session
+	queued *packets.Publish
+}
+
+func newTestBroker(t *testing.T, response *packets.Publish) *testBroker {
+	return newSessionTestBroker(t, response, nil)
+}
+
+func newSessionTestBroker(t *testing.T, response, queued *packets.Publish) *testBroker {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	broker := &testBroker{
+		listener:  listener,
+		conn:      make(chan net.Conn, 1),
+		connects:  make(chan *packets.Connect, 1),
+		published: make(chan *packets.Publish, 10),
+		queued:    queued,
+	}
+	go broker.serve(response)
+	return broker
+}
+
+func (broker *testBroker) url() *url.URL {
+	return &url.URL{Scheme: "tcp", Host: broker.listener.Addr().String()}
+}
+
+func (broker *testBroker) serve(response *packets.Publish) {
+	conn, err := broker.listener.Accept()
+	if err != nil {
+		return
+	}
+	broker.conn <- conn
+
+	for {
+		packet, err := packets.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		switch p := packet.Content.(type) {
+		case *packets.Connect:
+			_, _ = (&packets.Connack{Properties: &packets.Properties{}}).WriteTo(conn)
+			broker.connects <- p
+			if broker.queued != nil {
+				_, _ = broker.queued.WriteTo(conn)
+			}
+		case *packets.Subscribe:
+			_, _ = (&packets.Suback{Properties: &packets.Properties{}, PacketID: p.PacketID, Reasons: []byte{1}}).WriteTo(conn)
+			_, _ = response.WriteTo(conn)
+		case *packets.Publish:
+			if p.QoS == 1 {
+				_, _ = (&packets.Puback{Properties: &packets.Properties{}, PacketID: p.PacketID}).WriteTo(conn)
+			}
+			broker.published <- p
+		}
+	}
+}
+
+func TestMqtt5Client(t *testing.T) {
+	w := expect.WrapT(t)
+
+	broker := newTestBroker(t, &packets.Publish{
+		Topic:      "rfid/controller/response",
+		Payload:    []byte(`{"jsonrpc":"2.0","id":"","result":[]}`),
+		Properties: &packets.Properties{CorrelationData: []byte("request-1")},
+	})
+	defer broker.listener.Close()
+
+	d := &Driver{
+		Logger: logger.NewClient("test", false, "", "DEBUG"),
+		Config: &configuration{MqttClientId: "test", MqttKeepAlive: 30, MqttProtocolVersion: mqttVersion5},
+	}
+	client := d.newMqtt5Client(broker.url(), nil)
+	connected := make(chan struct{}, 1)
+	lost := make(chan error, 1)
+	client.onConnect = func(brokerClient) { connected <- struct{}{} }
+	client.onConnectionLost = func(_ brokerClient, err error) { lost <- err }
+
+	w.ShouldBeFalse(client.IsConnected())
+	w.As("not connected").ShouldNotBeNil(client.Publish("topic", 1, false, "data").Error())
+
+	token := client.Connect()
+	w.ShouldBeTrue(token.WaitTimeout(5 * time.Second))
+	w.ShouldSucceed(token.Error())
+	<-connected
+	w.ShouldBeTrue(client.IsConnected())
+
+	// the response is matched by its correlation data, not its jsonrpc id
+	messages := make(chan mqtt.Message, 1)
+	token = client.Subscribe("rfid/controller/response", 1, func(_ mqtt.Client, message mqtt.Message) {
+		messages <- message
+	})
+	w.ShouldBeTrue(token.WaitTimeout(5 * time.Second))
+	w.ShouldSucceed(token.Error())
+	message := <-messages
+	w.ShouldBeEqual(message.Topic(), "rfid/controller/response")
+	w.ShouldBeEqual(correlationData(controllerMessage{Message: message}), []byte("request-1"))
+
+	expiry := uint32(10)
+	token = client.publishRequest("rfid/controller/command", 1, []byte("request"), &paho.PublishProperties{
+		ResponseTopic:   "rfid/controller/response",
+		CorrelationData: []byte("request-2"),
+		MessageExpiry:   &expiry,
+	})
+	w.ShouldBeTrue(token.WaitTimeout(5 * time.Second))
+	w.ShouldSucceed(token.Error())
+	published := <-broker.published
+	w.ShouldBeEqual(published.Topic, "rfid/controller/command")
+	w.ShouldBeEqual(published.Properties.ResponseTopic, "rfid/controller/response")
+	w.ShouldBeEqual(published.Properties.CorrelationData, []byte("request-2"))
+	w.ShouldBeEqual(*published.Properties.MessageExpiry, expiry)
+
+	// losing the connection is reported once
+	(<-broker.conn).Close()
+	select {
+	case err := <-lost:
+		w.ShouldNotBeNil(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection loss was not reported")
+	}
+	w.ShouldBeFalse(client.IsConnected())
+	client.Disconnect(0)
+}
+
+func TestCorrelationData_mqtt311(t *testing.T) {
+	w := expect.WrapT(t)
+	w.ShouldBeNil(correlationData(controllerMessage{Message: testMessage(`{}`)}))
+}
+
+func TestMqtt5Client_PersistentSession(t *testing.T) {
+	w := expect.WrapT(t)
+
+	broker := newSessionTestBroker(t, nil, &packets.Publish{
+		Topic:      "rfid/rsp/data/RSP-150000",
+		Payload:    []byte(`{}`),
+		Properties: &packets.Properties{},
+	})
+	defer broker.listener.Close()
+
+	d := &Driver{
+		Logger: logger.NewClient("test", false, "", "DEBUG"),
+		Config: &configuration{MqttClientId: "test", MqttKeepAlive: 30, MqttCleanSession: false},
+	}
+	client := d.newMqtt5Client(broker.url(), nil)
+	client.onConnect = func(brokerClient) {}
+	client.onConnectionLost = func(brokerClient, error) {}
+
+	// the session's messages are routed before anything is subscribed
+	messages := make(chan mqtt.Message, 1)
+	client.AddRoute("$share/rsp/rfid/rsp/data/+", func(_ mqtt.Client, message mqtt.Message) {
+		messages <- message
+	})
+	client.AddRoute("rfid/rsp/data/+", func(_ mqtt.Client, message mqtt.Message) {
+		t.Error("a second route replaced the first")
+	})
+
+	token := client.Connect()
+	w.ShouldBeTrue(token.WaitTimeout(5 * time.Second))
+	w.ShouldSucceed(token.Error())
+	defer client.Disconnect(0)
+
+	connect := <-broker.connects
+	w.ShouldBeFalse(connect.CleanStart)
+	w.ShouldBeEqual(*connect.Properties.SessionExpiryInterval, uint32(0xFFFFFFFF))
+
+	select {
+	case message := <-messages:
+		w.ShouldBeEqual(message.Topic(), "rfid/rsp/data/RSP-150000")
+	case <-time.After(5 * time.Second):
+		t.Fatal("message of the resumed session was not routed")
+	}
+}
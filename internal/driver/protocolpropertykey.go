@@ -13,10 +13,34 @@
 package driver
 
 const (
-	ControllerName          = "ControllerName"
-	MaxWaitTimeForReq       = "MaxWaitTimeForReq"
-	MaxReconnectWaitSeconds = "MaxReconnectWaitSeconds"
-	TlsInsecureSkipVerify   = "TlsInsecureSkipVerify"
+	ControllerName                   = "ControllerName"
+	ControllerIds                    = "ControllerIds"
+	MaxWaitTimeForReq                = "MaxWaitTimeForReq"
+	MaxReconnectWaitSeconds          = "MaxReconnectWaitSeconds"
+	RetryInitialWaitMillis           = "RetryInitialWaitMillis"
+	RetryMaxWaitSeconds              = "RetryMaxWaitSeconds"
+	RetryJitterPercent               = "RetryJitterPercent"
+	SensorHeartbeatPeriodSeconds     = "SensorHeartbeatPeriodSeconds"
+	SensorMissedHeartbeats           = "SensorMissedHeartbeats"
+	ControllerHeartbeatPeriodSeconds = "ControllerHeartbeatPeriodSeconds"
+	ControllerMissedHeartbeats       = "ControllerMissedHeartbeats"
+	StatusAddress                    = "StatusAddress"
+	ShutdownWaitSeconds              = "ShutdownWaitSeconds"
+	TlsInsecureSkipVerify            = "TlsInsecureSkipVerify"
+	TlsCaFile                        = "TlsCaFile"
+	TlsCertFile                      = "TlsCertFile"
+	TlsKeyFile                       = "TlsKeyFile"
+	TlsServerName                    = "TlsServerName"
+	TlsMinVersion                    = "TlsMinVersion"
+	SensorDeviceReadings             = "SensorDeviceReadings"
+	InventoryTagReadings             = "InventoryTagReadings"
+	IncomingWorkers                  = "IncomingWorkers"
+
+	// ReadingBufferDir enables buffering readings on disk while EdgeX isn't accepting them
+	ReadingBufferDir           = "ReadingBufferDir"
+	CoreDataURL                = "CoreDataURL"
+	ReadingBufferMaxBytes      = "ReadingBufferMaxBytes"
+	ReadingBufferMaxAgeSeconds = "ReadingBufferMaxAgeSeconds"
 
 	// IncomingTopics provide reads to be sent to EdgeX.
 	IncomingTopics = "IncomingTopics"
@@ -27,21 +51,42 @@ const (
 	// RspControllerNotifications a slice of the notification types we want to receive from the rsp controller
 	RspControllerNotifications = "RspControllerNotifications"
 
-	MqttScheme    = "MqttScheme"
-	MqttHost      = "MqttHost"
-	MqttPort      = "MqttPort"
-	MqttUser      = "MqttUser"
-	MqttPassword  = "MqttPassword"
-	MqttKeepAlive = "MqttKeepAlive"
-	MqttClientId  = "MqttClientId"
+	// MqttBrokers is a list of broker URIs used in place of the MqttScheme, MqttHost and MqttPort
+	MqttBrokers = "MqttBrokers"
+	// MqttFailbackSeconds is the period of checking whether to fail back to the first of the MqttBrokers
+	MqttFailbackSeconds = "MqttFailbackSeconds"
+	MqttScheme          = "MqttScheme"
+	MqttHost            = "MqttHost"
+	MqttPort            = "MqttPort"
+	MqttUser            = "MqttUser"
+	MqttPassword        = "MqttPassword"
+	MqttKeepAlive       = "MqttKeepAlive"
+	MqttClientId        = "MqttClientId"
+	// persistent sessions
+	MqttCleanSession = "MqttCleanSession"
+	MqttStoreDir     = "MqttStoreDir"
+	// SharedSubscriptionGroup is the group of the shared subscriptions to the IncomingTopics
+	SharedSubscriptionGroup = "SharedSubscriptionGroup"
+	// MqttProtocolVersion is 3.1.1 or 5
+	MqttProtocolVersion = "MqttProtocolVersion"
+
+	// settings of ws and wss brokers
+	MqttWebsocketPath    = "MqttWebsocketPath"
+	MqttWebsocketHeaders = "MqttWebsocketHeaders"
+	MqttProxy            = "MqttProxy"
 
 	CommandQos  = "CommandQos"
 	ResponseQos = "ResponseQos"
 	IncomingQos = "IncomingQos"
 
-	TagFormats          = "TagFormats"
-	TagBitBoundary      = "TagBitBoundary"
-	TagURIAuthorityName = "TagURIAuthorityName"
-	TagURIAuthorityDate = "TagURIAuthorityDate"
-	SGTINStrictDecoding = "SGTINStrictDecoding"
+	TagFormats             = "TagFormats"
+	TagBitTagHeaders       = "TagBitTagHeaders"
+	TagBitBoundary         = "TagBitBoundary"
+	TagURIAuthorityName    = "TagURIAuthorityName"
+	TagURIAuthorityDate    = "TagURIAuthorityDate"
+	SGTINStrictDecoding    = "SGTINStrictDecoding"
+	EPCStrictDecoding      = "EPCStrictDecoding"
+	TagRepresentations     = "TagRepresentations"
+	TagDigitalLinkDomain   = "TagDigitalLinkDomain"
+	TagDecodeFailurePolicy = "TagDecodeFailurePolicy"
 )
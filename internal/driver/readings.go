@@ -0,0 +1,336 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package driver
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/intel/rsp-sw-toolkit-im-suite-mqtt-device-service/internal/jsonrpc"
+	"github.com/pkg/errors"
+	"math"
+	"strconv"
+	"strings"
+
+	sdkModel "github.com/edgexfoundry/device-sdk-go/pkg/models"
+	"github.com/edgexfoundry/go-mod-core-contracts/clients/logger"
+	edgexModels "github.com/edgexfoundry/go-mod-core-contracts/models"
+)
+
+const (
+	// device resources of the per tag readings of inventory_data
+	tagEPCResource         = "inventory_tag_epc"
+	tagURIResource         = "inventory_tag_uri"
+	tagTagURIResource      = "inventory_tag_tag_uri"
+	tagElementsResource    = "inventory_tag_element_string"
+	tagDigitalLinkResource = "inventory_tag_digital_link"
+	tagAntennaIdResource   = "inventory_tag_antenna_id"
+	tagRSSIResource        = "inventory_tag_rssi"
+	tagFrequencyResource   = "inventory_tag_frequency"
+	tagLastReadOnResource  = "inventory_tag_last_read_on"
+
+	// notificationAttribute is a device resource attribute naming the notification
+	// that the resource's typed readings are extracted from
+	notificationAttribute = "notification"
+	// pathAttribute is the dot separated path of the value of the reading within
+	// the notification, e.g. "params.read_rate_per_second"; array elements are
+	// selected by their index
+	pathAttribute = "path"
+)
+
+// tagRead is a single read of a tag in an inventory_data notification. The
+// numeric fields are integers, but the RSP Controller may encode them as floats.
+// TagURI, ElementString and DigitalLink are set for the TagRepresentations of GS1 EPCs.
+type tagRead struct {
+	EPC           string      `json:"epc"`
+	URI           *string     `json:"uri"`
+	TagURI        *string     `json:"tag_uri"`
+	ElementString *string     `json:"element_string"`
+	DigitalLink   *string     `json:"digital_link"`
+	AntennaId     json.Number `json:"antenna_id"`
+	RSSI          json.Number `json:"rssi"`
+	Frequency     json.Number `json:"frequency"`
+	LastReadOn    json.Number `json:"last_read_on"`
+}
+
+// inventoryTagValues converts the tag reads of an inventory_data payload into
+// typed readings. The readings of each tag read share its last_read_on as their
+// origin, so they can be correlated by consumers. Tag reads with numbers that
+// aren't integers in the range of their readings are skipped with a warning.
+func inventoryTagValues(lc logger.LoggingClient, payload []byte) ([]*sdkModel.CommandValue, error) {
+	var data jsonrpc.Notification
+	if err := json.Unmarshal(payload, &data); err != nil {
+		return nil, errors.Wrap(err, "unable to unmarshal inventory data")
+	}
+
+	var reads []tagRead
+	if err := data.GetParam(paramDataKey, &reads); err != nil {
+		return nil, err
+	}
+
+	values := make([]*sdkModel.CommandValue, 0, len(reads)*6)
+	for _, read := range reads {
+		readValues, err := tagReadValues(read)
+		if err != nil {
+			lc.Warn("Skipping the readings of an invalid tag read",
+				"epc", read.EPC, "cause", err.Error())
+			continue
+		}
+		values = append(values, readValues...)
+	}
+
+	return values, nil
+}
+
+// tagReadValues returns the typed readings of a single tag read
+func tagReadValues(read tagRead) ([]*sdkModel.CommandValue, error) {
+	origin, err := parseInt(read.LastReadOn, 64)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid last_read_on %q", read.LastReadOn)
+	}
+
+	values := []*sdkModel.CommandValue{sdkModel.NewStringValue(tagEPCResource, origin, read.EPC)}
+	for _, v := range []struct {
+		resource string
+		value    *string
+	}{
+		{tagURIResource, read.URI},
+		{tagTagURIResource, read.TagURI},
+		{tagElementsResource, read.ElementString},
+		{tagDigitalLinkResource, read.DigitalLink},
+	} {
+		if v.value != nil {
+			values = append(values, sdkModel.NewStringValue(v.resource, origin, *v.value))
+		}
+	}
+
+	for _, v := range []struct {
+		resource string
+		value    json.Number
+	}{
+		{tagAntennaIdResource, read.AntennaId},
+		{tagRSSIResource, read.RSSI},
+		{tagFrequencyResource, read.Frequency},
+	} {
+		value, err := typedValue(v.resource, origin, v.value, sdkModel.Int32)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid %s %q", v.resource, v.value)
+		}
+		values = append(values, value)
+	}
+
+	lastReadOn, err := sdkModel.NewInt64Value(tagLastReadOnResource, origin, origin)
+	if err != nil {
+		return nil, err
+	}
+	return append(values, lastReadOn), nil
+}
+
+// notificationValue describes a typed reading extracted from a notification, as
+// declared by a device resource with notification and path attributes
+type notificationValue struct {
+	resource  string
+	path      []string
+	valueType sdkModel.ValueType
+}
+
+// notificationValues finds the device resources that declare notification
+// values, grouped by device profile name and then by notification method
+func notificationValues(profiles []edgexModels.DeviceProfile) (map[string]map[string][]notificationValue, error) {
+	values := make(map[string]map[string][]notificationValue)
+	for _, profile := range profiles {
+		for _, dr := range profile.DeviceResources {
+			method := dr.Attributes[notificationAttribute]
+			if method == "" {
+				continue
+			}
+
+			path := dr.Attributes[pathAttribute]
+			if path == "" {
+				return nil, errors.Errorf("device resource %q of profile %q is missing the %q attribute",
+					dr.Name, profile.Name, pathAttribute)
+			}
+
+			valueType := sdkModel.ParseValueType(dr.Properties.Value.Type)
+			if valueType == sdkModel.Binary {
+				return nil, errors.Errorf("device resource %q of profile %q can't be of type %s",
+					dr.Name, profile.Name, dr.Properties.Value.Type)
+			}
+
+			if values[profile.Name] == nil {
+				values[profile.Name] = make(map[string][]notificationValue)
+			}
+			values[profile.Name][method] = append(values[profile.Name][method], notificationValue{
+				resource:  dr.Name,
+				path:      strings.Split(path, "."),
+				valueType: valueType,
+			})
+		}
+	}
+	return values, nil
+}
+
+// extractValues returns the typed readings of the payload. Values missing from
+// the payload are skipped, since notifications may have optional fields, and
+// values which can't be converted to their type are skipped with a warning.
+func extractValues(lc logger.LoggingClient, payload []byte, origin int64, nvs []notificationValue) ([]*sdkModel.CommandValue, error) {
+	if len(nvs) == 0 {
+		return nil, nil
+	}
+
+	// decode numbers as json.Number to keep the precision of 64 bit integers
+	var data interface{}
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	if err := decoder.Decode(&data); err != nil {
+		return nil, errors.Wrap(err, "unable to decode notification")
+	}
+
+	values := make([]*sdkModel.CommandValue, 0, len(nvs))
+	for _, nv := range nvs {
+		raw, ok := lookupPath(data, nv.path)
+		if !ok || raw == nil {
+			continue
+		}
+
+		value, err := typedValue(nv.resource, origin, raw, nv.valueType)
+		if err != nil {
+			lc.Warn("Skipping typed reading of invalid value", "resourceName", nv.resource,
+				"path", strings.Join(nv.path, "."), "cause", err.Error())
+			continue
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// lookupPath follows the path through decoded JSON objects and arrays
+func lookupPath(data interface{}, path []string) (interface{}, bool) {
+	for _, key := range path {
+		switch node := data.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if data, ok = node[key]; !ok {
+				return nil, false
+			}
+		case []interface{}:
+			idx, err := strconv.Atoi(key)
+			if err != nil || idx < 0 || idx >= len(node) {
+				return nil, false
+			}
+			data = node[idx]
+		default:
+			return nil, false
+		}
+	}
+	return data, true
+}
+
+// typedValue converts a decoded JSON value to a CommandValue of the given type
+func typedValue(resource string, origin int64, raw interface{}, valueType sdkModel.ValueType) (*sdkModel.CommandValue, error) {
+	switch valueType {
+	case sdkModel.String:
+		if str, ok := raw.(string); ok {
+			return sdkModel.NewStringValue(resource, origin, str), nil
+		}
+		encoded, err := json.Marshal(raw)
+		if err != nil {
+			return nil, err
+		}
+		return sdkModel.NewStringValue(resource, origin, string(encoded)), nil
+
+	case sdkModel.Bool:
+		b, ok := raw.(bool)
+		if !ok {
+			return nil, errors.Errorf("%v is not a boolean", raw)
+		}
+		return sdkModel.NewBoolValue(resource, origin, b)
+	}
+
+	num, ok := raw.(json.Number)
+	if !ok {
+		return nil, errors.Errorf("%v is not a number", raw)
+	}
+
+	var value interface{}
+	var err error
+	var i int64
+	var u uint64
+	var f float64
+	switch valueType {
+	case sdkModel.Int8:
+		i, err = parseInt(num, 8)
+		value = int8(i)
+	case sdkModel.Int16:
+		i, err = parseInt(num, 16)
+		value = int16(i)
+	case sdkModel.Int32:
+		i, err = parseInt(num, 32)
+		value = int32(i)
+	case sdkModel.Int64:
+		value, err = parseInt(num, 64)
+	case sdkModel.Uint8:
+		u, err = parseUint(num, 8)
+		value = uint8(u)
+	case sdkModel.Uint16:
+		u, err = parseUint(num, 16)
+		value = uint16(u)
+	case sdkModel.Uint32:
+		u, err = parseUint(num, 32)
+		value = uint32(u)
+	case sdkModel.Uint64:
+		value, err = parseUint(num, 64)
+	case sdkModel.Float32:
+		f, err = strconv.ParseFloat(num.String(), 32)
+		value = float32(f)
+	case sdkModel.Float64:
+		value, err = strconv.ParseFloat(num.String(), 64)
+	default:
+		return nil, errors.Errorf("unsupported value type %v", valueType)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return sdkModel.NewCommandValue(resource, origin, value, valueType)
+}
+
+// parseInt parses a JSON number as an integer of the given bit size; numbers
+// encoded as floats are accepted if they have no fractional part
+func parseInt(num json.Number, bitSize int) (int64, error) {
+	str, err := integerString(num)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(str, 10, bitSize)
+}
+
+// parseUint is the unsigned equivalent of parseInt
+func parseUint(num json.Number, bitSize int) (uint64, error) {
+	str, err := integerString(num)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(str, 10, bitSize)
+}
+
+// integerString returns the number formatted without a fraction or exponent,
+// or an error if it isn't an integer
+func integerString(num json.Number) (string, error) {
+	str := num.String()
+	if !strings.ContainsAny(str, ".eE") {
+		return str, nil
+	}
+
+	f, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		return "", err
+	}
+	if f != math.Trunc(f) {
+		return "", errors.Errorf("%v is not an integer", num)
+	}
+	return strconv.FormatFloat(f, 'f', 0, 64), nil
+}
@@ -0,0 +1,205 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package driver
+
+import (
+	sdkModel "github.com/edgexfoundry/device-sdk-go/pkg/models"
+	"github.com/edgexfoundry/go-mod-core-contracts/clients/logger"
+	edgexModels "github.com/edgexfoundry/go-mod-core-contracts/models"
+	expect "github.com/intel/rsp-sw-toolkit-im-suite-expect"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInventoryTagValues(t *testing.T) {
+	w := expect.WrapT(t)
+	lc := logger.NewClient("test", false, "", "DEBUG")
+
+	payload := []byte(`{"jsonrpc":"2.0","method":"inventory_data","params":{
+		"device_id":"RSP-15077a",
+		"data":[
+			{"epc":"30143639F84191AD22901607","uri":"urn:epc:id:sgtin:0888446.067142.193853396487",
+			 "tid":null,"antenna_id":1.0,"last_read_on":1570840098434.0,"rssi":-608.0,"phase":-32.0,"frequency":903250.0},
+			{"epc":"0F00000000000C00000014D2",
+			 "tid":null,"antenna_id":0,"last_read_on":1570840098500,"rssi":-591,"phase":20,"frequency":911250}
+		]}}`)
+
+	values := w.ShouldHaveResult(inventoryTagValues(lc, payload)).([]*sdkModel.CommandValue)
+	w.StopOnMismatch().ShouldHaveLength(values, 11)
+
+	w.ShouldBeEqual(values[0].DeviceResourceName, tagEPCResource)
+	w.ShouldBeEqual(w.ShouldHaveResult(values[0].StringValue()), "30143639F84191AD22901607")
+	w.ShouldBeEqual(values[1].DeviceResourceName, tagURIResource)
+	w.ShouldBeEqual(w.ShouldHaveResult(values[1].StringValue()), "urn:epc:id:sgtin:0888446.067142.193853396487")
+	w.ShouldBeEqual(values[2].DeviceResourceName, tagAntennaIdResource)
+	w.ShouldBeEqual(w.ShouldHaveResult(values[2].Int32Value()), int32(1))
+	w.ShouldBeEqual(values[3].DeviceResourceName, tagRSSIResource)
+	w.ShouldBeEqual(w.ShouldHaveResult(values[3].Int32Value()), int32(-608))
+	w.ShouldBeEqual(values[4].DeviceResourceName, tagFrequencyResource)
+	w.ShouldBeEqual(w.ShouldHaveResult(values[4].Int32Value()), int32(903250))
+	w.ShouldBeEqual(values[5].DeviceResourceName, tagLastReadOnResource)
+	w.ShouldBeEqual(w.ShouldHaveResult(values[5].Int64Value()), int64(1570840098434))
+	for _, v := range values[:6] {
+		w.As("shared origin").ShouldBeEqual(v.Origin, int64(1570840098434))
+	}
+
+	w.As("no uri").ShouldBeEqual(values[7].DeviceResourceName, tagAntennaIdResource)
+	w.ShouldBeEqual(values[10].Origin, int64(1570840098500))
+
+	values = w.ShouldHaveResult(inventoryTagValues(lc, []byte(`{"jsonrpc":"2.0","method":"inventory_data","params":{
+		"data":[{"epc":"30143639F84191AD22901607","uri":"urn:epc:id:sgtin:0888446.067142.193853396487",
+			"tag_uri":"urn:epc:tag:sgtin-96:0.0888446.067142.193853396487",
+			"element_string":"(01)00888446671424(21)193853396487",
+			"digital_link":"https://id.gs1.org/01/00888446671424/21/193853396487",
+			"antenna_id":1,"last_read_on":1570840098434,"rssi":-608,"frequency":903250}]}}`))).([]*sdkModel.CommandValue)
+	w.StopOnMismatch().As("representations").ShouldHaveLength(values, 9)
+	w.ShouldBeEqual(values[2].DeviceResourceName, tagTagURIResource)
+	w.ShouldBeEqual(w.ShouldHaveResult(values[2].StringValue()), "urn:epc:tag:sgtin-96:0.0888446.067142.193853396487")
+	w.ShouldBeEqual(values[3].DeviceResourceName, tagElementsResource)
+	w.ShouldBeEqual(w.ShouldHaveResult(values[3].StringValue()), "(01)00888446671424(21)193853396487")
+	w.ShouldBeEqual(values[4].DeviceResourceName, tagDigitalLinkResource)
+	w.ShouldBeEqual(w.ShouldHaveResult(values[4].StringValue()), "https://id.gs1.org/01/00888446671424/21/193853396487")
+
+	values = w.ShouldHaveResult(inventoryTagValues(lc,
+		[]byte(`{"jsonrpc":"2.0","method":"inventory_data","params":{"data":[]}}`))).([]*sdkModel.CommandValue)
+	w.As("no reads").ShouldHaveLength(values, 0)
+
+	values = w.ShouldHaveResult(inventoryTagValues(lc, []byte(`{"jsonrpc":"2.0","method":"inventory_data","params":{
+		"data":[
+			{"epc":"0F01","antenna_id":1.5,"last_read_on":1570840098434,"rssi":-608,"frequency":903250},
+			{"epc":"0F02","antenna_id":1,"last_read_on":1570840098434,"rssi":-608,"frequency":3000000000},
+			{"epc":"0F03","antenna_id":1,"last_read_on":1570840098434.5,"rssi":-608,"frequency":903250},
+			{"epc":"0F04","antenna_id":1,"last_read_on":1570840098434,"frequency":903250},
+			{"epc":"0F05","antenna_id":1,"last_read_on":1570840098434,"rssi":-608,"frequency":903250}
+		]}}`))).([]*sdkModel.CommandValue)
+	w.StopOnMismatch().As("invalid reads").ShouldHaveLength(values, 5)
+	w.ShouldBeEqual(w.ShouldHaveResult(values[0].StringValue()), "0F05")
+
+	w.As("no data").ShouldHaveError(inventoryTagValues(lc,
+		[]byte(`{"jsonrpc":"2.0","method":"inventory_data","params":{}}`)))
+	w.As("invalid json").ShouldHaveError(inventoryTagValues(lc, []byte(`data`)))
+}
+
+func TestNotificationValues(t *testing.T) {
+	w := expect.WrapT(t)
+
+	resource := func(name, valueType string, attributes map[string]string) edgexModels.DeviceResource {
+		return edgexModels.DeviceResource{
+			Name:       name,
+			Attributes: attributes,
+			Properties: edgexModels.ProfileProperty{Value: edgexModels.PropertyValue{Type: valueType}},
+		}
+	}
+
+	profiles := []edgexModels.DeviceProfile{{
+		Name: rspControllerDeviceProfile,
+		DeviceResources: []edgexModels.DeviceResource{
+			resource("inventory_read_rate_per_second", "String", map[string]string{"name": "inventory_read_rate_per_second"}),
+			resource("inventory_read_rate", "Int32", map[string]string{
+				notificationAttribute: "inventory_read_rate_per_second", pathAttribute: "params.read_rate_per_second"}),
+		},
+	}}
+
+	nvs := w.ShouldHaveResult(notificationValues(profiles)).(map[string]map[string][]notificationValue)
+	w.ShouldBeEqual(nvs, map[string]map[string][]notificationValue{
+		rspControllerDeviceProfile: {
+			"inventory_read_rate_per_second": {{
+				resource:  "inventory_read_rate",
+				path:      []string{"params", "read_rate_per_second"},
+				valueType: sdkModel.Int32,
+			}},
+		},
+	})
+
+	profiles[0].DeviceResources = append(profiles[0].DeviceResources,
+		resource("missing_path", "Int32", map[string]string{notificationAttribute: "heartbeat"}))
+	w.As("missing path").ShouldHaveError(notificationValues(profiles))
+}
+
+func TestExtractValues(t *testing.T) {
+	w := expect.WrapT(t)
+	lc := logger.NewClient("test", false, "", "DEBUG")
+	payload := []byte(`{"jsonrpc":"2.0","method":"m","params":{
+		"sent_on":1570840098444,"period":500.0,"ratio":0.25,"motion_detected":true,
+		"device_id":"RSP-15077a","location":{"latitude":1.5},
+		"data":[{"rssi":-608.0},{"rssi":-591}]}}`)
+
+	nv := func(resource, path string, valueType sdkModel.ValueType) notificationValue {
+		return notificationValue{resource: resource, path: strings.Split(path, "."), valueType: valueType}
+	}
+
+	values := w.ShouldHaveResult(extractValues(lc, payload, 7, []notificationValue{
+		nv("sent_on", "params.sent_on", sdkModel.Int64),
+		nv("period", "params.period", sdkModel.Uint16),
+		nv("ratio", "params.ratio", sdkModel.Float64),
+		nv("motion", "params.motion_detected", sdkModel.Bool),
+		nv("device", "params.device_id", sdkModel.String),
+		nv("location", "params.location", sdkModel.String),
+		nv("rssi", "params.data.1.rssi", sdkModel.Int32),
+		nv("missing", "params.facility_id", sdkModel.String),
+		nv("out_of_range", "params.data.2.rssi", sdkModel.Int32),
+	})).([]*sdkModel.CommandValue)
+
+	w.StopOnMismatch().ShouldHaveLength(values, 7)
+	w.ShouldBeEqual(w.ShouldHaveResult(values[0].Int64Value()), int64(1570840098444))
+	w.ShouldBeEqual(w.ShouldHaveResult(values[1].Uint16Value()), uint16(500))
+	w.ShouldBeEqual(w.ShouldHaveResult(values[2].Float64Value()), 0.25)
+	w.ShouldBeEqual(w.ShouldHaveResult(values[3].BoolValue()), true)
+	w.ShouldBeEqual(w.ShouldHaveResult(values[4].StringValue()), "RSP-15077a")
+	w.ShouldBeEqual(w.ShouldHaveResult(values[5].StringValue()), `{"latitude":1.5}`)
+	w.ShouldBeEqual(w.ShouldHaveResult(values[6].Int32Value()), int32(-591))
+	w.ShouldBeEqual(values[6].Origin, int64(7))
+
+	values = w.ShouldHaveResult(extractValues(lc, payload, 0, []notificationValue{
+		nv("fraction", "params.ratio", sdkModel.Int32),
+		nv("overflow", "params.sent_on", sdkModel.Int32),
+		nv("device", "params.device_id", sdkModel.String),
+		nv("negative_unsigned", "params.data.0.rssi", sdkModel.Uint32),
+		nv("not_a_bool", "params.sent_on", sdkModel.Bool),
+		nv("rssi", "params.data.0.rssi", sdkModel.Int32),
+	})).([]*sdkModel.CommandValue)
+	w.StopOnMismatch().As("invalid values").ShouldHaveLength(values, 2)
+	w.ShouldBeEqual(values[0].DeviceResourceName, "device")
+	w.ShouldBeEqual(values[1].DeviceResourceName, "rssi")
+
+	w.As("invalid json").ShouldHaveError(extractValues(lc, []byte(`data`), 0, []notificationValue{
+		nv("device", "params.device_id", sdkModel.String)}))
+}
+
+func TestNotificationValues_Profiles(t *testing.T) {
+	w := expect.WrapT(t)
+	lc := logger.NewClient("test", false, "", "DEBUG")
+
+	var profiles []edgexModels.DeviceProfile
+	for _, file := range []string{"rsp-controller.mqtt.device.profile.yml", "rsp.mqtt.device.profile.yml"} {
+		data := w.ShouldHaveResult(ioutil.ReadFile(filepath.Join("..", "..", "cmd", "res", file))).([]byte)
+		var profile edgexModels.DeviceProfile
+		w.As(file).ShouldSucceed(yaml.Unmarshal(data, &profile))
+		profiles = append(profiles, profile)
+	}
+	nvs := w.ShouldHaveResult(notificationValues(profiles)).(map[string]map[string][]notificationValue)
+
+	heartbeat := []byte(`{"jsonrpc":"2.0","method":"heartbeat","params":{"sent_on":1570840098444,
+		"device_id":"RSP-15077a","facility_id":"front","freq_plan":"US",
+		"location":{"latitude":45.5,"longitude":-122.75,"altitude":12}}}`)
+	for _, profile := range []string{rspControllerDeviceProfile, rspDeviceProfile} {
+		values := w.ShouldHaveResult(extractValues(lc, heartbeat, 0, nvs[profile]["heartbeat"])).([]*sdkModel.CommandValue)
+		w.StopOnMismatch().As(profile).ShouldHaveLength(values, 4)
+		w.ShouldBeEqual(w.ShouldHaveResult(values[0].Int64Value()), int64(1570840098444))
+		w.ShouldBeEqual(w.ShouldHaveResult(values[1].Float64Value()), 45.5)
+		w.ShouldBeEqual(w.ShouldHaveResult(values[2].Float64Value()), -122.75)
+		w.ShouldBeEqual(w.ShouldHaveResult(values[3].Float64Value()), 12.0)
+	}
+
+	// heartbeats without a location only have the time they were sent
+	values := w.ShouldHaveResult(extractValues(lc, []byte(`{"jsonrpc":"2.0","method":"heartbeat",
+		"params":{"sent_on":1570840098444,"location":null}}`), 0, nvs[rspDeviceProfile]["heartbeat"])).([]*sdkModel.CommandValue)
+	w.As("no location").ShouldHaveLength(values, 1)
+}
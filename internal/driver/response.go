@@ -23,7 +23,8 @@ import (
 	sdkModel "github.com/edgexfoundry/device-sdk-go/pkg/models"
 )
 
-// onCommandResponseReceived handles messages on the response topic and parses them as jsonrpc 2.0 Response messages
+// onCommandResponseReceived handles messages on the response topic and parses them as jsonrpc 2.0 Response messages.
+// They're matched to their command by their MQTT 5 correlation data if they have it, or else by their jsonrpc id.
 func (driver *Driver) onCommandResponseReceived(message mqtt.Message) {
 	var response jsonrpc.Response
 
@@ -32,10 +33,19 @@ func (driver *Driver) onCommandResponseReceived(message mqtt.Message) {
 		return
 	}
 
-	if response.Id != "" {
+	id := response.Id
+	if correlation := correlationData(message); len(correlation) > 0 {
+		id = string(correlation)
+	}
+
+	if id != "" {
 		driver.Logger.Info("[Response listener] Command response received", "topic", message.Topic(), "msg", string(message.Payload()))
-		if responseChan, ok := driver.responseMap.Load(response.Id); ok {
-			responseChan.(chan *jsonrpc.Response) <- &response
+		if pending, ok := driver.responseMap.Load(id); ok {
+			select {
+			case pending.(*pendingCommand).response <- &response:
+			default:
+				driver.Logger.Warn("[Response listener] Duplicate command response ignored", "id", id)
+			}
 		}
 	} else {
 		driver.Logger.Debug("[Response listener] Command response ignored. No ID found in the message",
@@ -43,19 +53,21 @@ func (driver *Driver) onCommandResponseReceived(message mqtt.Message) {
 	}
 }
 
-func (driver *Driver) createEdgeXResponse(deviceResourceName string, response *jsonrpc.Response) (*sdkModel.CommandValue, error) {
+// createEdgeXResponse validates the response to a request for the given jsonrpc
+// method and formats it as a reading of the device resource
+func (driver *Driver) createEdgeXResponse(method, deviceResourceName string, response *jsonrpc.Response) (*sdkModel.CommandValue, error) {
 	// Return just the result or error field from the jsonrpc response
 	origin := time.Now().UnixNano() / int64(time.Millisecond)
 
 	if len(response.Result) > 0 {
-		if err := driver.validateResponse(deviceResourceName, response.Result); err != nil {
-			return nil, errors.Wrapf(err, "Validation failed for %q: %+v", deviceResourceName, err)
+		if err := driver.validateResponse(method, response.Result); err != nil {
+			return nil, errors.Wrapf(err, "Validation failed for %q: %+v", method, err)
 		}
-		driver.Logger.Info("Get command finished successfully", "response.result", string(response.Result))
+		driver.Logger.Info("Command finished successfully", "response.result", string(response.Result))
 		return sdkModel.NewStringValue(deviceResourceName, origin, string(response.Result)), nil
 
 	} else if len(response.Error) > 0 {
-		driver.Logger.Info("Get command finished with an error", "response.error", string(response.Error))
+		driver.Logger.Info("Command finished with an error", "response.error", string(response.Error))
 		return nil, fmt.Errorf(string(response.Error))
 	}
 
@@ -0,0 +1,75 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package driver
+
+import (
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// sharedSubscriptionPrefix starts the filter of a shared subscription,
+// which is followed by the name of the group and the topic filter
+const sharedSubscriptionPrefix = "$share/"
+
+// sharedSubscriptionPrefix returns the prefix which makes a filter a subscription
+// shared with the other members of the SharedSubscriptionGroup, or "" if there's no group
+func (config *configuration) sharedSubscriptionPrefix() (string, error) {
+	group := config.SharedSubscriptionGroup
+	if group == "" {
+		return "", nil
+	}
+	if strings.ContainsAny(group, "/+#") {
+		return "", errors.Errorf("%s %q must not contain '/', '+' or '#'", SharedSubscriptionGroup, group)
+	}
+	return sharedSubscriptionPrefix + group + "/", nil
+}
+
+// unshared returns the topic filter of a shared subscription filter,
+// or the filter itself if it isn't shared
+func unshared(filter string) string {
+	if !strings.HasPrefix(filter, sharedSubscriptionPrefix) {
+		return filter
+	}
+	parts := strings.SplitN(filter, "/", 3)
+	if len(parts) < 3 {
+		return filter
+	}
+	return parts[2]
+}
+
+// incomingFilters returns the filters subscribed to for the IncomingTopics of the
+// controller. In a SharedSubscriptionGroup, each message is delivered to only one
+// of the instances of the device service.
+func (driver *Driver) incomingFilters(controller *rspController) []string {
+	filters := make([]string, len(controller.IncomingTopics))
+	for i, topic := range controller.IncomingTopics {
+		filters[i] = driver.sharedPrefix + topic
+	}
+	return filters
+}
+
+// instanceResponseTopic returns the topic the controller is asked to send command
+// responses to. With MQTT 5, it's specific to this instance of the device service,
+// so that responses only go to the instance which sent the command; MQTT 3.1.1 can't
+// ask for a response topic, so the responses of all instances arrive on the ResponseTopic.
+func (driver *Driver) instanceResponseTopic(controller *rspController) string {
+	if !driver.mqtt5 {
+		return controller.ResponseTopic
+	}
+	return controller.ResponseTopic + "/" + driver.Config.MqttClientId
+}
+
+// responseTopics returns the topics command responses of the controller arrive on.
+// Responses are never shared: they must reach the instance waiting for them.
+func (driver *Driver) responseTopics(controller *rspController) []string {
+	topics := []string{controller.ResponseTopic}
+	if instanceTopic := driver.instanceResponseTopic(controller); instanceTopic != controller.ResponseTopic {
+		// controllers which don't support MQTT 5 respond on the ResponseTopic
+		topics = append(topics, instanceTopic)
+	}
+	return topics
+}
@@ -0,0 +1,85 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package driver
+
+import (
+	"github.com/eclipse/paho.golang/packets"
+	"github.com/eclipse/paho.mqtt.golang"
+	"github.com/edgexfoundry/go-mod-core-contracts/clients/logger"
+	expect "github.com/intel/rsp-sw-toolkit-im-suite-expect"
+	"testing"
+	"time"
+)
+
+func TestSharedSubscriptionPrefix(t *testing.T) {
+	w := expect.WrapT(t)
+	w.ShouldBeEqual(w.ShouldHaveResult((&configuration{}).sharedSubscriptionPrefix()), "")
+	w.ShouldBeEqual(w.ShouldHaveResult((&configuration{SharedSubscriptionGroup: "rsp"}).sharedSubscriptionPrefix()), "$share/rsp/")
+	w.ShouldHaveError((&configuration{SharedSubscriptionGroup: "rsp/a"}).sharedSubscriptionPrefix())
+	w.ShouldHaveError((&configuration{SharedSubscriptionGroup: "rsp+"}).sharedSubscriptionPrefix())
+
+	w.ShouldBeEqual(unshared("$share/rsp/rfid/rsp/data/+"), "rfid/rsp/data/+")
+	w.ShouldBeEqual(unshared("rfid/rsp/data/+"), "rfid/rsp/data/+")
+}
+
+func TestSubscriptionTopics(t *testing.T) {
+	w := expect.WrapT(t)
+	controller := &rspController{
+		ResponseTopic:  "rfid/controller/response",
+		IncomingTopics: []string{"rfid/controller/alerts", "rfid/rsp/data/+"},
+	}
+	d := &Driver{Config: &configuration{MqttClientId: "instance-1"}}
+
+	w.ShouldBeEqual(d.incomingFilters(controller), controller.IncomingTopics)
+	w.ShouldBeEqual(d.instanceResponseTopic(controller), "rfid/controller/response")
+	w.ShouldBeEqual(d.responseTopics(controller), []string{"rfid/controller/response"})
+
+	d.sharedPrefix = "$share/rsp/"
+	d.mqtt5 = true
+	w.ShouldBeEqual(d.incomingFilters(controller),
+		[]string{"$share/rsp/rfid/controller/alerts", "$share/rsp/rfid/rsp/data/+"})
+	w.ShouldBeEqual(d.instanceResponseTopic(controller), "rfid/controller/response/instance-1")
+	w.ShouldBeEqual(d.responseTopics(controller),
+		[]string{"rfid/controller/response", "rfid/controller/response/instance-1"})
+}
+
+func TestMqtt5Client_SharedSubscription(t *testing.T) {
+	w := expect.WrapT(t)
+
+	broker := newTestBroker(t, &packets.Publish{
+		Topic:      "rfid/rsp/data/RSP-150000",
+		Payload:    []byte(`{}`),
+		Properties: &packets.Properties{},
+	})
+	defer broker.listener.Close()
+
+	d := &Driver{
+		Logger: logger.NewClient("test", false, "", "DEBUG"),
+		Config: &configuration{MqttClientId: "test", MqttKeepAlive: 30},
+	}
+	client := d.newMqtt5Client(broker.url(), nil)
+	client.onConnect = func(brokerClient) {}
+	client.onConnectionLost = func(brokerClient, error) {}
+	token := client.Connect()
+	token.Wait()
+	w.ShouldSucceed(token.Error())
+	defer client.Disconnect(0)
+
+	messages := make(chan mqtt.Message, 1)
+	token = client.Subscribe("$share/rsp/rfid/rsp/data/+", 1, func(_ mqtt.Client, message mqtt.Message) {
+		messages <- message
+	})
+	token.Wait()
+	w.ShouldSucceed(token.Error())
+
+	select {
+	case message := <-messages:
+		w.ShouldBeEqual(message.Topic(), "rfid/rsp/data/RSP-150000")
+	case <-time.After(5 * time.Second):
+		t.Fatal("message of the shared subscription was not routed")
+	}
+}
@@ -0,0 +1,155 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package driver
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// statusRoute reports the driverStatus; it responds with 200 OK while the
+	// driver is connected to the broker and 503 Service Unavailable otherwise
+	statusRoute = "/api/v1/status"
+
+	subscriptionSubscribed = "subscribed"
+	subscriptionRetrying   = "retrying"
+	subscriptionPending    = "pending"
+)
+
+// driverStatus describes the connection of the driver to the broker
+type driverStatus struct {
+	Health    string `json:"health"`
+	Connected bool   `json:"connected"`
+	Broker    string `json:"broker"`
+	// Subscriptions are the states of the topic filters the driver subscribes to
+	Subscriptions map[string]string `json:"subscriptions"`
+	Retries       retryState        `json:"retries"`
+	// WatchdogRemainingSeconds is the time left to connect before the service stops
+	// with a failure, or nil if the driver isn't waiting for a connection
+	WatchdogRemainingSeconds *int64 `json:"watchdogRemainingSeconds"`
+	// TagDecoders are the number of tags decoded by each decoder, by name
+	TagDecoders map[string]uint64 `json:"tagDecoders"`
+	// TagDecodeFailures are the number of tags which couldn't be decoded
+	TagDecodeFailures tagDecodeFailures `json:"tagDecodeFailures"`
+}
+
+// tagDecodeFailures are the number of tags which couldn't be decoded by the
+// decoders which tried them, and by the device_id of the sensor which read them
+type tagDecodeFailures struct {
+	Decoders map[string]uint64 `json:"decoders"`
+	Devices  map[string]uint64 `json:"devices"`
+}
+
+// isConnected returns true if the driver is connected to the broker. Unlike the
+// IsConnected of the paho client, it's false while the client re-connects.
+func (driver *Driver) isConnected() bool {
+	return atomic.LoadInt32(&driver.connected) == 1
+}
+
+// setConnected records whether the driver is connected to the broker
+func (driver *Driver) setConnected(connected bool) {
+	var value int32
+	if connected {
+		value = 1
+	} else {
+		driver.subscribed.Range(func(filter, _ interface{}) bool {
+			driver.subscribed.Delete(filter)
+			return true
+		})
+	}
+	atomic.StoreInt32(&driver.connected, value)
+}
+
+// status returns the current driverStatus
+func (driver *Driver) status() driverStatus {
+	broker := *driver.activeBroker()
+	broker.User = nil
+
+	status := driverStatus{
+		Health:        driver.health().String(),
+		Connected:     driver.isConnected(),
+		Broker:        broker.String(),
+		Subscriptions: map[string]string{},
+		Retries:       driver.retries.snapshot(),
+		TagDecoders:   map[string]uint64{},
+		TagDecodeFailures: tagDecodeFailures{
+			Decoders: map[string]uint64{},
+			Devices:  driver.tagDecodeFailures.snapshot(),
+		},
+	}
+	if driver.DecoderRing != nil {
+		status.TagDecoders = driver.DecoderRing.Matches()
+		status.TagDecodeFailures.Decoders = driver.DecoderRing.Failures()
+	}
+
+	for _, s := range driver.subscriptions() {
+		state := subscriptionPending
+		if _, ok := status.Retries.Subscriptions[s.filter]; ok {
+			state = subscriptionRetrying
+		} else if _, ok := driver.subscribed.Load(s.filter); ok {
+			state = subscriptionSubscribed
+		}
+		status.Subscriptions[s.filter] = state
+	}
+
+	if deadline, _ := driver.watchdogDeadline.Load().(time.Time); !deadline.IsZero() {
+		remaining := int64(time.Until(deadline) / time.Second)
+		if remaining < 0 {
+			remaining = 0
+		}
+		status.WatchdogRemainingSeconds = &remaining
+	}
+	return status
+}
+
+// serveStatus handles requests for the statusRoute
+func (driver *Driver) serveStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	status := driver.status()
+	w.Header().Set("Content-Type", "application/json")
+	if !status.Connected {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		driver.Logger.Warn("Unable to write the driver status", "cause", err.Error())
+	}
+}
+
+// startStatusServer serves the statusRoute on the StatusAddress until done is signaled
+func (driver *Driver) startStatusServer() error {
+	listener, err := net.Listen("tcp", driver.Config.StatusAddress)
+	if err != nil {
+		return errors.Wrapf(err, "unable to listen on %s %q", StatusAddress, driver.Config.StatusAddress)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(statusRoute, driver.serveStatus)
+	server := &http.Server{Handler: mux}
+
+	go func() {
+		<-driver.done
+		_ = server.Close()
+	}()
+	go func() {
+		if err := server.Serve(listener); err != http.ErrServerClosed {
+			driver.Logger.Error("The status server stopped", "cause", err.Error())
+		}
+	}()
+
+	driver.Logger.Info("Serving the driver status", "address", listener.Addr().String(), "route", statusRoute)
+	return nil
+}
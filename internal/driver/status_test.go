@@ -0,0 +1,99 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package driver
+
+import (
+	"encoding/json"
+	"github.com/edgexfoundry/go-mod-core-contracts/clients/logger"
+	expect "github.com/intel/rsp-sw-toolkit-im-suite-expect"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func newStatusTestDriver() *Driver {
+	return &Driver{
+		Logger: logger.NewClient("test", false, "", "DEBUG"),
+		Config: &configuration{},
+		broker: &url.URL{Scheme: "tcp", Host: "broker:1883", User: url.UserPassword("user", "secret")},
+		controllers: []*rspController{{
+			ResponseTopic:  "rfid/controller/response",
+			IncomingTopics: []string{"rfid/controller/alerts", "rfid/rsp/data/+"},
+		}},
+	}
+}
+
+func TestStatus(t *testing.T) {
+	w := expect.WrapT(t)
+	d := newStatusTestDriver()
+
+	status := d.status()
+	w.ShouldBeFalse(status.Connected)
+	w.ShouldBeEqual(status.Health, healthStarting.String())
+	w.ShouldBeEqual(status.Broker, "tcp://broker:1883")
+	w.ShouldBeNil(status.WatchdogRemainingSeconds)
+	w.ShouldBeEqual(status.Subscriptions, map[string]string{
+		"rfid/controller/response": subscriptionPending,
+		"rfid/controller/alerts":   subscriptionPending,
+		"rfid/rsp/data/+":          subscriptionPending,
+	})
+
+	d.setConnected(true)
+	d.subscribed.Store("rfid/controller/response", true)
+	d.retries.subscribeFailed("rfid/rsp/data/+", 3)
+	d.watchdogDeadline.Store(time.Now().Add(time.Minute + time.Second))
+	status = d.status()
+	w.ShouldBeTrue(status.Connected)
+	w.ShouldBeEqual(*status.WatchdogRemainingSeconds, int64(60))
+	w.ShouldBeEqual(status.Retries.Subscriptions["rfid/rsp/data/+"], 3)
+	w.ShouldBeEqual(status.Subscriptions, map[string]string{
+		"rfid/controller/response": subscriptionSubscribed,
+		"rfid/controller/alerts":   subscriptionPending,
+		"rfid/rsp/data/+":          subscriptionRetrying,
+	})
+
+	// subscriptions are made again on the next connection
+	d.setConnected(false)
+	w.ShouldBeEqual(d.status().Subscriptions["rfid/controller/response"], subscriptionPending)
+}
+
+func TestServeStatus(t *testing.T) {
+	w := expect.WrapT(t)
+	d := newStatusTestDriver()
+
+	recorder := httptest.NewRecorder()
+	d.serveStatus(recorder, httptest.NewRequest(http.MethodGet, statusRoute, nil))
+	w.ShouldBeEqual(recorder.Code, http.StatusServiceUnavailable)
+	w.ShouldBeEqual(recorder.Header().Get("Content-Type"), "application/json")
+
+	d.setConnected(true)
+	recorder = httptest.NewRecorder()
+	d.serveStatus(recorder, httptest.NewRequest(http.MethodGet, statusRoute, nil))
+	w.ShouldBeEqual(recorder.Code, http.StatusOK)
+	var status driverStatus
+	w.ShouldSucceed(json.Unmarshal(recorder.Body.Bytes(), &status))
+	w.ShouldBeTrue(status.Connected)
+	w.ShouldBeEqual(len(status.Subscriptions), 3)
+
+	recorder = httptest.NewRecorder()
+	d.serveStatus(recorder, httptest.NewRequest(http.MethodPost, statusRoute, nil))
+	w.ShouldBeEqual(recorder.Code, http.StatusMethodNotAllowed)
+}
+
+func TestStartStatusServer(t *testing.T) {
+	w := expect.WrapT(t)
+	d := newStatusTestDriver()
+	d.done = make(chan interface{})
+	d.Config.StatusAddress = "127.0.0.1:0"
+	w.ShouldSucceed(d.startStatusServer())
+	close(d.done)
+
+	d.Config.StatusAddress = "not an address"
+	w.ShouldFail(d.startStatusServer())
+}
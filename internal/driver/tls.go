@@ -0,0 +1,228 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package driver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/edgexfoundry/go-mod-core-contracts/clients/logger"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsFiles holds the CA bundle and client certificate used for the mqtt connection.
+// The files are checked on every handshake and reloaded when they change, so
+// rotated certificates are picked up without a restart.
+type tlsFiles struct {
+	logger   logger.LoggingClient
+	caFile   string
+	certFile string
+	keyFile  string
+
+	mu          sync.Mutex
+	caPool      *x509.CertPool
+	caModTime   time.Time
+	cert        *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+}
+
+// createTLSConfig builds the TLS configuration for the mqtt connection from the
+// driver config; the CA and client certificate files are loaded once up front
+// so that configuration mistakes are reported right away.
+func (driver *Driver) createTLSConfig() (*tls.Config, error) {
+	config := driver.Config
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: config.TlsInsecureSkipVerify,
+		ServerName:         config.TlsServerName,
+	}
+
+	if config.TlsMinVersion != "" {
+		version, ok := tlsVersions[config.TlsMinVersion]
+		if !ok {
+			return nil, errors.Errorf("unsupported %s %q; use one of 1.0, 1.1, 1.2 or 1.3",
+				TlsMinVersion, config.TlsMinVersion)
+		}
+		tlsConfig.MinVersion = version
+	}
+
+	if (config.TlsCertFile == "") != (config.TlsKeyFile == "") {
+		return nil, errors.Errorf("%s and %s must be configured together", TlsCertFile, TlsKeyFile)
+	}
+
+	files := &tlsFiles{
+		logger:   driver.Logger,
+		caFile:   config.TlsCaFile,
+		certFile: config.TlsCertFile,
+		keyFile:  config.TlsKeyFile,
+	}
+
+	if files.certFile != "" {
+		if _, err := files.clientCertificate(); err != nil {
+			return nil, err
+		}
+		tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return files.clientCertificate()
+		}
+	}
+
+	if files.caFile != "" && !config.TlsInsecureSkipVerify {
+		if _, err := files.rootCAs(); err != nil {
+			return nil, err
+		}
+		// the standard verification can only use a fixed pool of CAs, so it's
+		// replaced by the same verification against the current CA bundle; that
+		// needs the host name of the broker, which brokerTLSConfig sets up
+		driver.tlsFiles = files
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyPeerCertificate = func([][]byte, [][]*x509.Certificate) error {
+			return errors.New("broker certificate can't be verified without the broker's host name")
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// brokerTLSConfig returns the TLS configuration for connections to the broker,
+// which checks the broker's certificate against its host name, or the
+// TlsServerName if that's configured
+func (driver *Driver) brokerTLSConfig(broker *url.URL) *tls.Config {
+	if driver.tlsConfig == nil {
+		return nil
+	}
+
+	tlsConfig := driver.tlsConfig.Clone()
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = broker.Hostname()
+	}
+	if files := driver.tlsFiles; files != nil {
+		serverName := tlsConfig.ServerName
+		tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return files.verifyPeer(rawCerts, serverName)
+		}
+	}
+	return tlsConfig
+}
+
+// clientCertificate returns the client certificate, reloading it if its files changed
+func (files *tlsFiles) clientCertificate() (*tls.Certificate, error) {
+	files.mu.Lock()
+	defer files.mu.Unlock()
+
+	certInfo, err := os.Stat(files.certFile)
+	if err != nil {
+		return files.cert, files.keepCurrent(files.cert != nil, errors.Wrap(err, "unable to read client certificate"))
+	}
+	keyInfo, err := os.Stat(files.keyFile)
+	if err != nil {
+		return files.cert, files.keepCurrent(files.cert != nil, errors.Wrap(err, "unable to read client key"))
+	}
+
+	if files.cert != nil && certInfo.ModTime().Equal(files.certModTime) && keyInfo.ModTime().Equal(files.keyModTime) {
+		return files.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(files.certFile, files.keyFile)
+	if err != nil {
+		// the files may be mid-rotation; try again on the next handshake
+		return files.cert, files.keepCurrent(files.cert != nil, errors.Wrap(err, "unable to load client certificate"))
+	}
+
+	if files.cert != nil {
+		files.logger.Info("Reloaded mqtt client certificate", "file", files.certFile)
+	}
+	files.cert = &cert
+	files.certModTime = certInfo.ModTime()
+	files.keyModTime = keyInfo.ModTime()
+	return files.cert, nil
+}
+
+// rootCAs returns the pool of CAs trusted for the broker, reloading it if its file changed
+func (files *tlsFiles) rootCAs() (*x509.CertPool, error) {
+	files.mu.Lock()
+	defer files.mu.Unlock()
+
+	info, err := os.Stat(files.caFile)
+	if err != nil {
+		return files.caPool, files.keepCurrent(files.caPool != nil, errors.Wrap(err, "unable to read CA file"))
+	}
+	if files.caPool != nil && info.ModTime().Equal(files.caModTime) {
+		return files.caPool, nil
+	}
+
+	pem, err := ioutil.ReadFile(files.caFile)
+	if err != nil {
+		return files.caPool, files.keepCurrent(files.caPool != nil, errors.Wrap(err, "unable to read CA file"))
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return files.caPool, files.keepCurrent(files.caPool != nil, errors.Errorf("no certificates found in CA file %q", files.caFile))
+	}
+
+	if files.caPool != nil {
+		files.logger.Info("Reloaded mqtt CA file", "file", files.caFile)
+	}
+	files.caPool = pool
+	files.caModTime = info.ModTime()
+	return files.caPool, nil
+}
+
+// keepCurrent logs the error and returns nil if the file was loaded previously,
+// so that its current contents are kept in use; otherwise it returns the error
+func (files *tlsFiles) keepCurrent(loaded bool, err error) error {
+	if !loaded {
+		return err
+	}
+	files.logger.Warn("Keeping previously loaded TLS files", "cause", err.Error())
+	return nil
+}
+
+// verifyPeer verifies the broker's certificate chain against the current CA
+// bundle and checks that it's valid for the server name
+func (files *tlsFiles) verifyPeer(rawCerts [][]byte, serverName string) error {
+	pool, err := files.rootCAs()
+	if err != nil {
+		return err
+	}
+	if pool == nil {
+		return errors.New("no CA certificates are loaded")
+	}
+	if len(rawCerts) == 0 {
+		return errors.New("broker did not present a certificate")
+	}
+
+	certs := make([]*x509.Certificate, len(rawCerts))
+	for i, raw := range rawCerts {
+		if certs[i], err = x509.ParseCertificate(raw); err != nil {
+			return errors.Wrap(err, "unable to parse broker certificate")
+		}
+	}
+
+	opts := x509.VerifyOptions{
+		Roots:         pool,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	if _, err := certs[0].Verify(opts); err != nil {
+		return err
+	}
+
+	return errors.Wrap(certs[0].VerifyHostname(serverName), "broker certificate is not valid for the broker")
+}
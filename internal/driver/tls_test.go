@@ -0,0 +1,266 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package driver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/edgexfoundry/go-mod-core-contracts/clients/logger"
+	expect "github.com/intel/rsp-sw-toolkit-im-suite-expect"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert is a certificate and its key, signed by its parent (or itself)
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+func newTestCert(t *testing.T, name string, parent *testCert, isCA bool, usage x509.ExtKeyUsage) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	if isCA {
+		template.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		template.KeyUsage = x509.KeyUsageDigitalSignature
+		template.ExtKeyUsage = []x509.ExtKeyUsage{usage}
+		template.DNSNames = []string{name}
+	}
+
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func (c *testCert) tlsCertificate(t *testing.T) tls.Certificate {
+	cert, err := tls.X509KeyPair(c.certPEM, c.keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// writeFile writes the data and moves its modification time forward, so the
+// change is noticed even on file systems with coarse timestamps
+func writeFile(t *testing.T, path string, data []byte, age time.Duration) {
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Now().Add(age)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCreateTLSConfig(t *testing.T) {
+	w := expect.WrapT(t)
+	dir, err := ioutil.TempDir("", "mqtt-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCert(t, "test-ca", nil, true, 0)
+	caFile := filepath.Join(dir, "ca.pem")
+	writeFile(t, caFile, ca.certPEM, 0)
+
+	d := &Driver{Logger: logger.NewClient("test", false, "", "DEBUG")}
+
+	d.Config = &configuration{TlsMinVersion: "1.2"}
+	tlsConfig := w.ShouldHaveResult(d.createTLSConfig()).(*tls.Config)
+	w.ShouldBeEqual(tlsConfig.MinVersion, uint16(tls.VersionTLS12))
+	w.ShouldBeFalse(tlsConfig.InsecureSkipVerify)
+	w.ShouldBeNil(tlsConfig.GetClientCertificate)
+
+	d.Config = &configuration{TlsMinVersion: "1.4"}
+	w.As("bad version").ShouldHaveError(d.createTLSConfig())
+
+	d.Config = &configuration{TlsCertFile: filepath.Join(dir, "client.pem")}
+	w.As("cert without key").ShouldHaveError(d.createTLSConfig())
+
+	d.Config = &configuration{TlsCaFile: filepath.Join(dir, "missing.pem")}
+	w.As("missing CA").ShouldHaveError(d.createTLSConfig())
+
+	d.Config = &configuration{TlsCaFile: caFile, TlsInsecureSkipVerify: true}
+	tlsConfig = w.ShouldHaveResult(d.createTLSConfig()).(*tls.Config)
+	w.As("insecure").ShouldBeTrue(tlsConfig.InsecureSkipVerify)
+	w.As("insecure").ShouldBeNil(tlsConfig.VerifyPeerCertificate)
+}
+
+func TestTLSFiles_Reload(t *testing.T) {
+	w := expect.WrapT(t)
+	dir, err := ioutil.TempDir("", "mqtt-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca1 := newTestCert(t, "ca-1", nil, true, 0)
+	ca2 := newTestCert(t, "ca-2", nil, true, 0)
+	broker1 := newTestCert(t, "broker.local", ca1, false, x509.ExtKeyUsageServerAuth)
+	broker2 := newTestCert(t, "broker.local", ca2, false, x509.ExtKeyUsageServerAuth)
+	client1 := newTestCert(t, "client-1", ca1, false, x509.ExtKeyUsageClientAuth)
+	client2 := newTestCert(t, "client-2", ca1, false, x509.ExtKeyUsageClientAuth)
+
+	files := &tlsFiles{
+		logger:   logger.NewClient("test", false, "", "DEBUG"),
+		caFile:   filepath.Join(dir, "ca.pem"),
+		certFile: filepath.Join(dir, "client.pem"),
+		keyFile:  filepath.Join(dir, "client.key"),
+	}
+	writeFile(t, files.caFile, ca1.certPEM, -time.Minute)
+	writeFile(t, files.certFile, client1.certPEM, -time.Minute)
+	writeFile(t, files.keyFile, client1.keyPEM, -time.Minute)
+
+	names := "broker.local"
+	w.ShouldSucceed(files.verifyPeer([][]byte{broker1.cert.Raw}, names))
+	w.As("wrong name").ShouldFail(files.verifyPeer([][]byte{broker1.cert.Raw}, "other.local"))
+	w.As("other CA").ShouldFail(files.verifyPeer([][]byte{broker2.cert.Raw}, names))
+
+	cert := w.ShouldHaveResult(files.clientCertificate()).(*tls.Certificate)
+	w.ShouldBeEqual(cert.Certificate[0], client1.cert.Raw)
+
+	// rotate everything
+	writeFile(t, files.caFile, ca2.certPEM, 0)
+	writeFile(t, files.certFile, client2.certPEM, 0)
+	writeFile(t, files.keyFile, client2.keyPEM, 0)
+
+	w.As("rotated CA").ShouldSucceed(files.verifyPeer([][]byte{broker2.cert.Raw}, names))
+	w.As("old CA").ShouldFail(files.verifyPeer([][]byte{broker1.cert.Raw}, names))
+	cert = w.ShouldHaveResult(files.clientCertificate()).(*tls.Certificate)
+	w.As("rotated client cert").ShouldBeEqual(cert.Certificate[0], client2.cert.Raw)
+
+	// a broken rotation keeps the last good files
+	writeFile(t, files.caFile, []byte("not a certificate"), time.Minute)
+	w.As("broken CA").ShouldSucceed(files.verifyPeer([][]byte{broker2.cert.Raw}, names))
+}
+
+func TestCreateTLSConfig_MutualTLS(t *testing.T) {
+	w := expect.WrapT(t)
+	dir, err := ioutil.TempDir("", "mqtt-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCert(t, "test-ca", nil, true, 0)
+	broker := newTestCert(t, "broker.local", ca, false, x509.ExtKeyUsageServerAuth)
+	client := newTestCert(t, "client", ca, false, x509.ExtKeyUsageClientAuth)
+
+	d := &Driver{
+		Logger: logger.NewClient("test", false, "", "DEBUG"),
+		Config: &configuration{
+			MqttHost:      "10.0.0.1",
+			TlsCaFile:     filepath.Join(dir, "ca.pem"),
+			TlsCertFile:   filepath.Join(dir, "client.pem"),
+			TlsKeyFile:    filepath.Join(dir, "client.key"),
+			TlsServerName: "broker.local",
+		},
+	}
+	writeFile(t, d.Config.TlsCaFile, ca.certPEM, 0)
+	writeFile(t, d.Config.TlsCertFile, client.certPEM, 0)
+	writeFile(t, d.Config.TlsKeyFile, client.keyPEM, 0)
+
+	d.tlsConfig = w.ShouldHaveResult(d.createTLSConfig()).(*tls.Config)
+	clientConfig := d.brokerTLSConfig(&url.URL{Scheme: "ssl", Host: "10.0.0.1:8883"})
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+	serverConfig := &tls.Config{
+		Certificates: []tls.Certificate{broker.tlsCertificate(t)},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	}
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		server := tls.Server(serverConn, serverConfig)
+		serverErr <- server.Handshake()
+	}()
+
+	c := tls.Client(clientConn, clientConfig)
+	w.ShouldSucceed(c.Handshake())
+	w.ShouldSucceed(<-serverErr)
+}
+
+func TestBrokerTLSConfig(t *testing.T) {
+	w := expect.WrapT(t)
+	dir, err := ioutil.TempDir("", "mqtt-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCert(t, "test-ca", nil, true, 0)
+	primary := newTestCert(t, "primary.local", ca, false, x509.ExtKeyUsageServerAuth)
+
+	d := &Driver{
+		Logger: logger.NewClient("test", false, "", "DEBUG"),
+		Config: &configuration{TlsCaFile: filepath.Join(dir, "ca.pem")},
+	}
+	writeFile(t, d.Config.TlsCaFile, ca.certPEM, 0)
+	d.tlsConfig = w.ShouldHaveResult(d.createTLSConfig()).(*tls.Config)
+	w.As("without host").ShouldFail(d.tlsConfig.VerifyPeerCertificate([][]byte{primary.cert.Raw}, nil))
+
+	primaryConfig := d.brokerTLSConfig(&url.URL{Scheme: "ssl", Host: "primary.local:8883"})
+	w.ShouldBeEqual(primaryConfig.ServerName, "primary.local")
+	w.ShouldSucceed(primaryConfig.VerifyPeerCertificate([][]byte{primary.cert.Raw}, nil))
+
+	// a certificate valid for another of the brokers isn't accepted
+	standbyConfig := d.brokerTLSConfig(&url.URL{Scheme: "ssl", Host: "standby.local:8883"})
+	w.ShouldBeEqual(standbyConfig.ServerName, "standby.local")
+	w.As("other broker").ShouldFail(standbyConfig.VerifyPeerCertificate([][]byte{primary.cert.Raw}, nil))
+}
@@ -0,0 +1,308 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package driver
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"github.com/edgexfoundry/go-mod-core-contracts/clients/logger"
+	"github.com/pkg/errors"
+	"golang.org/x/net/websocket"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// websocketProtocol is the WebSocket subprotocol of MQTT
+	websocketProtocol    = "mqtt"
+	websocketDialTimeout = 30 * time.Second
+)
+
+// isWebsocket returns true if the broker is reached over a WebSocket
+func isWebsocket(broker *url.URL) bool {
+	return broker.Scheme == "ws" || broker.Scheme == "wss"
+}
+
+// websocketHeaders parses the MqttWebsocketHeaders, which are "Name: value" pairs
+func (config *configuration) websocketHeaders() (http.Header, error) {
+	headers := http.Header{}
+	for _, header := range config.MqttWebsocketHeaders {
+		parts := strings.SplitN(header, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, errors.Errorf("invalid header %q in %s; use \"Name: value\"", header, MqttWebsocketHeaders)
+		}
+		headers.Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	}
+	return headers, nil
+}
+
+// websocketProxy returns the URL of the HTTP proxy to reach the broker through,
+// or nil to connect directly. If MqttProxy isn't configured, the standard
+// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables are used.
+func (config *configuration) websocketProxy(broker *url.URL) (*url.URL, error) {
+	if config.MqttProxy != "" {
+		proxyURL, err := url.Parse(config.MqttProxy)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid %s %q", MqttProxy, config.MqttProxy)
+		}
+		if proxyURL.Scheme != "http" || proxyURL.Host == "" {
+			return nil, errors.Errorf("%s must be an http:// URL with a host", MqttProxy)
+		}
+		return proxyURL, nil
+	}
+
+	// the environment is looked up by the scheme of the equivalent http request
+	target := *broker
+	target.Scheme = strings.Replace(broker.Scheme, "ws", "http", 1)
+	return http.ProxyFromEnvironment(&http.Request{URL: &target})
+}
+
+// websocketDialer opens WebSockets to a broker, with the path, headers, proxy
+// and TLS configuration of the connection
+type websocketDialer struct {
+	broker    *url.URL
+	headers   http.Header
+	proxy     *url.URL
+	tlsConfig *tls.Config
+}
+
+// newWebsocketDialer creates a websocketDialer for the broker based on the driver config
+func (driver *Driver) newWebsocketDialer(broker *url.URL) (*websocketDialer, error) {
+	headers, err := driver.Config.websocketHeaders()
+	if err != nil {
+		return nil, err
+	}
+	proxy, err := driver.Config.websocketProxy(broker)
+	if err != nil {
+		return nil, err
+	}
+
+	return &websocketDialer{
+		broker:    broker,
+		headers:   headers,
+		proxy:     proxy,
+		tlsConfig: driver.brokerTLSConfig(broker),
+	}, nil
+}
+
+// needsBridge returns true if the MQTT 3.1.1 client reaches the broker through a
+// websocketBridge. The mqtt library opens WebSockets by itself, but can't use a proxy.
+func (driver *Driver) needsBridge(broker *url.URL) bool {
+	if driver.mqtt5 || !isWebsocket(broker) {
+		return false
+	}
+	proxy, err := driver.Config.websocketProxy(broker)
+	return err != nil || proxy != nil
+}
+
+// dial connects to the broker, through the proxy if there is one, and opens a WebSocket
+func (dialer *websocketDialer) dial() (*websocket.Conn, error) {
+	address := dialer.broker.Host
+	var conn net.Conn
+	var err error
+	if dialer.proxy != nil {
+		conn, err = dialProxy(dialer.proxy, address)
+	} else {
+		conn, err = net.DialTimeout("tcp", address, websocketDialTimeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	origin := &url.URL{Scheme: "http", Host: dialer.broker.Host}
+	if dialer.broker.Scheme == "wss" {
+		origin.Scheme = "https"
+
+		tlsConfig := &tls.Config{}
+		if dialer.tlsConfig != nil {
+			tlsConfig = dialer.tlsConfig.Clone()
+		}
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName = dialer.broker.Hostname()
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		_ = tlsConn.SetDeadline(time.Now().Add(websocketDialTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			_ = conn.Close()
+			return nil, errors.Wrap(err, "TLS handshake failed")
+		}
+		_ = tlsConn.SetDeadline(time.Time{})
+		conn = tlsConn
+	}
+
+	config, err := websocket.NewConfig(dialer.broker.String(), origin.String())
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	config.Protocol = []string{websocketProtocol}
+	config.Header = dialer.headers
+
+	_ = conn.SetDeadline(time.Now().Add(websocketDialTimeout))
+	ws, err := websocket.NewClient(config, conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, errors.Wrap(err, "websocket handshake failed")
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	ws.PayloadType = websocket.BinaryFrame
+	return ws, nil
+}
+
+// websocketBridge carries the connection of a single MQTT 3.1.1 client to the
+// broker over a WebSocket, for brokers the mqtt library can't reach by itself.
+// It listens on a unix socket in a directory only the service's user can access,
+// accepts exactly one connection, which must come from this process, and stops
+// listening right after that; every connection attempt of the client needs a
+// new bridge.
+type websocketBridge struct {
+	logger   logger.LoggingClient
+	dialer   *websocketDialer
+	dir      string
+	listener net.Listener
+
+	closeOnce sync.Once
+}
+
+// newWebsocketBridge starts a bridge using the dialer
+func newWebsocketBridge(lc logger.LoggingClient, dialer *websocketDialer) (*websocketBridge, error) {
+	dir, err := ioutil.TempDir("", "mqtt-bridge")
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create the websocket bridge directory")
+	}
+	listener, err := net.Listen("unix", filepath.Join(dir, "bridge.sock"))
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, errors.Wrap(err, "unable to listen for the websocket bridge")
+	}
+
+	bridge := &websocketBridge{
+		logger:   lc,
+		dialer:   dialer,
+		dir:      dir,
+		listener: listener,
+	}
+	go bridge.serve()
+	return bridge, nil
+}
+
+// address returns the URI the mqtt client connects to instead of the broker
+func (bridge *websocketBridge) address() *url.URL {
+	return &url.URL{Scheme: "unix", Host: bridge.listener.Addr().String()}
+}
+
+// Close stops listening, if the bridge still is; the connection it accepted ends with the mqtt client's
+func (bridge *websocketBridge) Close() {
+	bridge.closeOnce.Do(func() {
+		_ = bridge.listener.Close()
+		_ = os.RemoveAll(bridge.dir)
+	})
+}
+
+// serve accepts the connection of the client and stops listening
+func (bridge *websocketBridge) serve() {
+	local, err := bridge.listener.Accept()
+	bridge.Close()
+	if err != nil {
+		return
+	}
+
+	if err := checkBridgePeer(local); err != nil {
+		bridge.logger.Error("Rejected connection to the websocket bridge", "cause", err.Error())
+		_ = local.Close()
+		return
+	}
+	bridge.handle(local)
+}
+
+// handle copies data between the local connection and a new WebSocket to the broker
+func (bridge *websocketBridge) handle(local net.Conn) {
+	ws, err := bridge.dialer.dial()
+	if err != nil {
+		bridge.logger.Error("unable to open websocket to mqtt broker",
+			"broker", bridge.dialer.broker.String(), "proxy", proxyString(bridge.dialer.proxy), "cause", err.Error())
+		_ = local.Close()
+		return
+	}
+
+	done := make(chan struct{}, 2)
+	go func() {
+		_, _ = io.Copy(ws, local)
+		done <- struct{}{}
+	}()
+	go func() {
+		_, _ = io.Copy(local, ws)
+		done <- struct{}{}
+	}()
+
+	// when either side closes, close both
+	<-done
+	_ = ws.Close()
+	_ = local.Close()
+	<-done
+}
+
+// dialProxy opens a tunnel to the address through the HTTP proxy
+func dialProxy(proxy *url.URL, address string) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", proxy.Host, websocketDialTimeout)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to connect to proxy")
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: http.Header{},
+	}
+	if proxy.User != nil {
+		password, _ := proxy.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(proxy.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+
+	_ = conn.SetDeadline(time.Now().Add(websocketDialTimeout))
+	if err := req.Write(conn); err != nil {
+		_ = conn.Close()
+		return nil, errors.Wrap(err, "unable to send proxy request")
+	}
+	// nothing is sent through the tunnel before the proxy responds, so the
+	// buffered reader can't hold any of the broker's data
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		_ = conn.Close()
+		return nil, errors.Wrap(err, "unable to read proxy response")
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		_ = conn.Close()
+		return nil, errors.Errorf("proxy refused the connection: %s", resp.Status)
+	}
+	// the body of a successful response is the tunnel itself, so it isn't closed
+	_ = conn.SetDeadline(time.Time{})
+
+	return conn, nil
+}
+
+// proxyString describes the proxy for logging, without its credentials
+func proxyString(proxy *url.URL) string {
+	if proxy == nil {
+		return "none"
+	}
+	return fmt.Sprintf("%s://%s", proxy.Scheme, proxy.Host)
+}
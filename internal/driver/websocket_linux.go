@@ -0,0 +1,45 @@
+//go:build linux
+// +build linux
+
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package driver
+
+import (
+	"github.com/pkg/errors"
+	"net"
+	"os"
+	"syscall"
+)
+
+// checkBridgePeer returns an error unless the connection to the websocketBridge
+// comes from this process
+func checkBridgePeer(conn net.Conn) error {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return errors.New("not a unix socket connection")
+	}
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var cred *syscall.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil {
+		return err
+	}
+	if credErr != nil {
+		return errors.Wrap(credErr, "unable to get the credentials of the peer")
+	}
+	if int(cred.Pid) != os.Getpid() {
+		return errors.Errorf("connection from another process (%d)", cred.Pid)
+	}
+	return nil
+}
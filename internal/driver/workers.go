@@ -0,0 +1,86 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package driver
+
+import (
+	"hash/fnv"
+	"runtime"
+	"strings"
+)
+
+// incomingWorkerCount returns the number of workers to process incoming data with;
+// if IncomingWorkers isn't positive, there's one per CPU
+func (config *configuration) incomingWorkerCount() int {
+	if config.IncomingWorkers > 0 {
+		return config.IncomingWorkers
+	}
+	return runtime.NumCPU()
+}
+
+// startIncomingWorkers starts count workers which pass the messages dispatched
+// to them to handle, one at a time, until they're stopped
+func (driver *Driver) startIncomingWorkers(count int, handle func(controllerMessage)) {
+	driver.incomingWorkers = make([]chan controllerMessage, count)
+	for i := range driver.incomingWorkers {
+		ch := make(chan controllerMessage, incomingDataMessageBuffer)
+		driver.incomingWorkers[i] = ch
+
+		driver.senders.Add(1)
+		go func() {
+			defer driver.senders.Done()
+			for msg := range ch {
+				handle(msg)
+			}
+		}()
+	}
+}
+
+// stopIncomingWorkers lets the workers finish the messages already dispatched
+// to them, then waits for them to exit or for abort to be signaled
+func (driver *Driver) stopIncomingWorkers() {
+	for _, ch := range driver.incomingWorkers {
+		close(ch)
+	}
+
+	if !waitGroupDone(&driver.senders, driver.abort) {
+		driver.Logger.Warn("Shutdown deadline passed; incoming workers are still processing messages")
+	}
+}
+
+// dispatchIncoming queues the message on a worker chosen by its ordering key,
+// so that messages with the same key are handled in the order they arrived
+func (driver *Driver) dispatchIncoming(message controllerMessage) {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(incomingOrderingKey(message)))
+	worker := driver.incomingWorkers[h.Sum32()%uint32(len(driver.incomingWorkers))]
+
+	select {
+	case worker <- message:
+	case <-driver.abort:
+		driver.Logger.Warn("Shutdown deadline passed; dropping incoming message", "topic", message.Topic())
+	}
+}
+
+// incomingOrderingKey returns the device_id of the sensor that produced the
+// message, or the id of the controller it came from if it isn't a sensor's.
+// Sensors publish on topics whose last segment is their device_id, matched by a
+// single-level wildcard at the end of one of the controller's IncomingTopics;
+// the key comes from the topic so the payload isn't parsed before dispatch.
+func incomingOrderingKey(message controllerMessage) string {
+	topic := message.Topic()
+	for _, filter := range message.controller.IncomingTopics {
+		if !strings.HasSuffix(filter, "/+") {
+			continue
+		}
+		prefix := strings.TrimSuffix(filter, "+")
+		if deviceId := strings.TrimPrefix(topic, prefix); len(deviceId) < len(topic) &&
+			deviceId != "" && !strings.Contains(deviceId, "/") {
+			return "sensor/" + deviceId
+		}
+	}
+	return "controller/" + message.controller.Id
+}
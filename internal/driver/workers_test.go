@@ -0,0 +1,98 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package driver
+
+import (
+	"encoding/json"
+	"fmt"
+	expect "github.com/intel/rsp-sw-toolkit-im-suite-expect"
+	"sync"
+	"testing"
+)
+
+// testMessage is an mqtt.Message with the given payload
+type testMessage []byte
+
+func (m testMessage) Duplicate() bool   { return false }
+func (m testMessage) Qos() byte         { return 0 }
+func (m testMessage) Retained() bool    { return false }
+func (m testMessage) Topic() string     { return "test" }
+func (m testMessage) MessageID() uint16 { return 0 }
+func (m testMessage) Payload() []byte   { return m }
+func (m testMessage) Ack()              {}
+
+// topicMessage is a testMessage received on the given topic
+type topicMessage struct {
+	testMessage
+	topic string
+}
+
+func (m topicMessage) Topic() string { return m.topic }
+
+func TestIncomingOrderingKey(t *testing.T) {
+	w := expect.WrapT(t)
+	controller := &rspController{Id: "store1", IncomingTopics: []string{
+		"rfid/store1/controller/heartbeat", "rfid/store1/rsp/data/+", "rfid/store1/rsp/rsp_status/+"}}
+
+	key := func(topic string) string {
+		return incomingOrderingKey(controllerMessage{
+			Message: topicMessage{testMessage(`{}`), topic}, controller: controller})
+	}
+
+	w.As("data").ShouldBeEqual(key("rfid/store1/rsp/data/RSP-1508b2"), "sensor/RSP-1508b2")
+	w.As("status").ShouldBeEqual(key("rfid/store1/rsp/rsp_status/RSP-1508b2"), "sensor/RSP-1508b2")
+	w.As("controller topic").ShouldBeEqual(key("rfid/store1/controller/heartbeat"), "controller/store1")
+	w.As("no device_id").ShouldBeEqual(key("rfid/store1/rsp/data/"), "controller/store1")
+	w.As("deeper topic").ShouldBeEqual(key("rfid/store1/rsp/data/RSP-1508b2/x"), "controller/store1")
+	w.As("other controller").ShouldBeEqual(key("rfid/store2/rsp/data/RSP-1508b2"), "controller/store1")
+}
+
+func TestDispatchIncoming_OrderPerDevice(t *testing.T) {
+	w := expect.WrapT(t)
+	d := &Driver{}
+
+	const devices, perDevice = 8, 50
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	received := make(map[string][]int)
+	wg.Add(devices * perDevice)
+
+	d.startIncomingWorkers(4, func(msg controllerMessage) {
+		defer wg.Done()
+		var data struct {
+			Params struct {
+				DeviceId string `json:"device_id"`
+				Seq      int    `json:"seq"`
+			} `json:"params"`
+		}
+		w.ShouldSucceed(json.Unmarshal(msg.Payload(), &data))
+
+		mu.Lock()
+		received[data.Params.DeviceId] = append(received[data.Params.DeviceId], data.Params.Seq)
+		mu.Unlock()
+	})
+
+	controller := &rspController{IncomingTopics: []string{"rfid/rsp/data/+"}}
+	for seq := 0; seq < perDevice; seq++ {
+		for dev := 0; dev < devices; dev++ {
+			payload := fmt.Sprintf(`{"params":{"device_id":"RSP-%d","seq":%d}}`, dev, seq)
+			message := topicMessage{testMessage(payload), fmt.Sprintf("rfid/rsp/data/RSP-%d", dev)}
+			d.dispatchIncoming(controllerMessage{Message: message, controller: controller})
+		}
+	}
+	wg.Wait()
+	d.stopIncomingWorkers()
+
+	w.StopOnMismatch().ShouldHaveLength(received, devices)
+	for device, seqs := range received {
+		w.As(device).StopOnMismatch().ShouldHaveLength(seqs, perDevice)
+		for i, seq := range seqs {
+			w.As(device).ShouldBeEqual(seq, i)
+		}
+	}
+}
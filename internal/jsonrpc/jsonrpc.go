@@ -44,21 +44,11 @@ type Request struct {
 	Params  json.RawMessage `json:"params"`
 }
 
-type RSPCommandRequest struct {
-	Request                // embed
-	Params  DeviceIdParams `json:"params"`
-}
-
 type RSPControllerSubscribeRequest struct {
 	Request          // embed
 	Params  []string `json:"params"`
 }
 
-// DeviceIdParams holds the device id parameter used in command requests to RSP Controller
-type DeviceIdParams struct {
-	DeviceId string `json:"device_id"`
-}
-
 func NewRequest(method string) Request {
 	return Request{
 		Version: Version,
@@ -67,13 +57,12 @@ func NewRequest(method string) Request {
 	}
 }
 
-func NewRSPCommandRequest(method string, deviceId string) RSPCommandRequest {
-	return RSPCommandRequest{
-		Request: NewRequest(method),
-		Params: DeviceIdParams{
-			DeviceId: deviceId,
-		},
-	}
+// NewRequestWithParams returns a Request for method whose params are the
+// given, already marshaled, JSON value.
+func NewRequestWithParams(method string, params json.RawMessage) Request {
+	req := NewRequest(method)
+	req.Params = params
+	return req
 }
 
 func NewRSPControllerSubscribeRequest(topics []string) RSPControllerSubscribeRequest {
@@ -71,3 +71,13 @@ func TestNotification_UnmarshalJSON(t *testing.T) {
 	w.ShouldSucceed(json.Unmarshal(data, &n))
 	w.As("no parameters").ShouldFail(n.GetParam("b", s))
 }
+
+func TestNewRequestWithParams(t *testing.T) {
+	w := expect.WrapT(t)
+	r := NewRequestWithParams("my_method", json.RawMessage(`{"a":"b"}`))
+	w.ShouldNotBeEqual(r.Id, "")
+
+	marshaled := w.ShouldHaveResult(json.Marshal(r)).([]byte)
+	w.ShouldBeEqual(marshaled, []byte(`{"jsonrpc":"2.0","id":"`+r.Id+
+		`","method":"my_method","params":{"a":"b"}}`))
+}